@@ -0,0 +1,257 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "encoding/json"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    ErasureDelete = "delete"
+    ErasureAnonymise = "anonymise"
+)
+
+// function used to collect all data stored for a given user into a
+// single archive. note that the hashes of API tokens are never exported
+func buildAccountArchive(ctx context.Context, uid string) (models.AccountArchive, error) {
+    logger(ctx).Info(fmt.Sprintf("building account archive for user %s", uid))
+    archive := models.AccountArchive{Uid: uid, ExportedAt: time.Now().UTC()}
+    var err error
+    if archive.WorkPeriods, err = persistence.getAllWorkPeriods(ctx, uid); err != nil {
+        return archive, err
+    }
+    if archive.Timesheets, err = persistence.getTimesheetSubmissions(ctx, uid); err != nil {
+        return archive, err
+    }
+    if archive.Teams, err = persistence.getUserTeams(ctx, uid); err != nil {
+        return archive, err
+    }
+    if archive.Roles, err = persistence.getUserRoles(ctx, uid); err != nil {
+        return archive, err
+    }
+    if archive.APITokens, err = persistence.getAPITokens(ctx, uid); err != nil {
+        return archive, err
+    }
+    if archive.AuditEntries, err = persistence.getAuditEntries(ctx, uid); err != nil {
+        return archive, err
+    }
+    return archive, nil
+}
+
+// function used to write account archive for a given user as JSON attachment
+func writeAccountArchive(ctx *gin.Context, uid string) {
+    archive, err := buildAccountArchive(ctx.Request.Context(), uid)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to build account archive for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=account_%s.json", uid))
+    ctx.JSON(200, archive)
+}
+
+// function used to erase all data for a given user. the erasure mode
+// is read from the mode query parameter and defaults to deletion
+func eraseAccount(ctx *gin.Context, uid string) {
+    mode := ctx.DefaultQuery("mode", ErasureDelete)
+    if mode != ErasureDelete && mode != ErasureAnonymise {
+        StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("invalid erasure mode %s", mode))
+        return
+    }
+    log.Info(fmt.Sprintf("erasing account for user %s with mode %s", uid, mode))
+    summary, err := persistence.eraseUserData(ctx.Request.Context(), uid, getUser(ctx), mode)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to erase data for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": summary})
+}
+
+// function used to export all data stored for the current user
+func exportAccountHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to export account for user %s", user))
+    writeAccountArchive(ctx, user)
+}
+
+// function used to delete or anonymise all data for the current user
+func eraseAccountHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to erase account for user %s", user))
+    eraseAccount(ctx, user)
+}
+
+// function used by admins to export all data stored for a given user
+func exportUserAccountHandler(ctx *gin.Context) {
+    uid := ctx.Param("uid")
+    log.Debug(fmt.Sprintf("received admin request to export account for user %s", uid))
+    writeAccountArchive(ctx, uid)
+}
+
+// function used by admins to delete or anonymise all data for a given user
+func eraseUserAccountHandler(ctx *gin.Context) {
+    uid := ctx.Param("uid")
+    log.Debug(fmt.Sprintf("received admin request to erase account for user %s", uid))
+    eraseAccount(ctx, uid)
+}
+
+// ###########################################################
+// # Define persistence functions used to export and erase accounts
+// ###########################################################
+
+// function used to retrieve all work periods for a user, including
+// periods that are still active
+func(db Persistence) getAllWorkPeriods(ctx context.Context, uid string) ([]models.WorkPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("fetching all work periods for user %s", uid))
+    periods := []models.WorkPeriod{}
+    rows, err := db.conn.Query(ctx, "SELECT period_id FROM work_periods WHERE uid=$1 ORDER BY created_at", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve work periods for user %s: %v", uid, err))
+        return periods, err
+    }
+    periodIds := []uuid.UUID{}
+    for rows.Next() {
+        var periodId uuid.UUID
+        if err := rows.Scan(&periodId); err != nil {
+            rows.Close()
+            logger(ctx).Error(fmt.Errorf("unable to process work period: %v", err))
+            return periods, err
+        }
+        periodIds = append(periodIds, periodId)
+    }
+    rows.Close()
+
+    for _, periodId := range(periodIds) {
+        period, err := db.getWorkPeriod(ctx, periodId)
+        if err != nil {
+            return periods, err
+        }
+        periods = append(periods, period)
+    }
+    return periods, nil
+}
+
+// function used to retrieve all audit entries for a given user
+func(db Persistence) getAuditEntries(ctx context.Context, uid string) ([]models.AuditEntry, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving audit entries for user %s", uid))
+    entries := []models.AuditEntry{}
+    rows, err := db.conn.Query(ctx, "SELECT entry_id,uid,actor,action,details,created_at FROM audit_log WHERE uid=$1 ORDER BY created_at", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve audit entries for user %s: %v", uid, err))
+        return entries, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var (entry models.AuditEntry; details []byte)
+        if err := rows.Scan(&entry.EntryId, &entry.Uid, &entry.Actor, &entry.Action, &details, &entry.CreatedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process audit entry: %v", err))
+            return entries, err
+        }
+        entry.Details = json.RawMessage(details)
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
+
+// function used to insert audit entry using the given transaction
+func insertAuditEntry(ctx context.Context, tx pgx.Tx, uid, actor, action string, details interface{}) error {
+    body, err := json.Marshal(details)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(ctx, "INSERT INTO audit_log(entry_id, uid, actor, action, details, created_at) VALUES($1,$2,$3,$4,$5,$6)",
+        uuid.New(), uid, actor, action, body, time.Now())
+    return err
+}
+
+// function used to erase all data stored for a user within a single
+// transaction. work periods, breaks and timesheets are either deleted or
+// re-assigned to a random pseudonym, while all other personal data is
+// deleted. an audit entry recording the erasure is written in the same
+// transaction
+func(db Persistence) eraseUserData(ctx context.Context, uid, actor, mode string) (models.ErasureSummary, error) {
+    logger(ctx).Debug(fmt.Sprintf("erasing data for user %s", uid))
+    summary := models.ErasureSummary{Uid: uid, Mode: mode}
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return summary, err
+    }
+    defer tx.Rollback(ctx)
+
+    if mode == ErasureAnonymise {
+        pseudonym := fmt.Sprintf("anonymised-%s", uuid.New())
+        result, err := tx.Exec(ctx, "UPDATE work_periods SET uid=$1 WHERE uid=$2", pseudonym, uid)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to anonymise work periods: %v", err))
+            return summary, err
+        }
+        summary.WorkPeriods = result.RowsAffected()
+        result, err = tx.Exec(ctx, "UPDATE timesheet_submissions SET uid=$1 WHERE uid=$2", pseudonym, uid)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to anonymise timesheets: %v", err))
+            return summary, err
+        }
+        summary.Timesheets = result.RowsAffected()
+        if _, err := tx.Exec(ctx, "UPDATE period_events SET uid=$1 WHERE uid=$2", pseudonym, uid); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to anonymise events: %v", err))
+            return summary, err
+        }
+    } else {
+        result, err := tx.Exec(ctx, "DELETE FROM break_periods WHERE period_id IN (SELECT period_id FROM work_periods WHERE uid=$1)", uid)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to delete break periods: %v", err))
+            return summary, err
+        }
+        summary.BreakPeriods = result.RowsAffected()
+        result, err = tx.Exec(ctx, "DELETE FROM work_periods WHERE uid=$1", uid)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to delete work periods: %v", err))
+            return summary, err
+        }
+        summary.WorkPeriods = result.RowsAffected()
+        result, err = tx.Exec(ctx, "DELETE FROM timesheet_submissions WHERE uid=$1", uid)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to delete timesheets: %v", err))
+            return summary, err
+        }
+        summary.Timesheets = result.RowsAffected()
+        if _, err := tx.Exec(ctx, "DELETE FROM period_events WHERE uid=$1", uid); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to delete events: %v", err))
+            return summary, err
+        }
+    }
+    // remove all remaining personal data regardless of erasure mode
+    for _, statement := range([]string{
+        "DELETE FROM team_members WHERE uid=$1",
+        "DELETE FROM team_invitations WHERE uid=$1 OR invited_by=$1",
+        "DELETE FROM user_roles WHERE uid=$1",
+        "DELETE FROM api_tokens WHERE uid=$1",
+        "DELETE FROM webhooks WHERE uid=$1",
+        // deliveries for global webhooks contain the user ID in the payload
+        "DELETE FROM webhook_deliveries WHERE payload->>'uid'=$1",
+    }) {
+        if _, err := tx.Exec(ctx, statement, uid); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to erase personal data: %v", err))
+            return summary, err
+        }
+    }
+
+    if err := insertAuditEntry(ctx, tx, uid, actor, fmt.Sprintf("account.%s", mode), summary); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to write audit entry: %v", err))
+        return summary, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit erasure: %v", err))
+        return summary, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully erased data for user %s", uid))
+    return summary, nil
+}
@@ -3,28 +3,30 @@ package main
 import (
     "fmt"
     "time"
-    log "github.com/sirupsen/logrus"
+    "sort"
+    "context"
+    "github.com/PSauerborn/go-timesheets/models"
 )
 
 // function used to analyze list of breaks. both the total number of
 // breaks as well as the total number of break hours are returned
-func analyseBreaks(breaks []BreakPeriod) BreakPeriodAnalysisResults {
+func analyseBreaks(ctx context.Context, breaks []models.BreakPeriod) models.BreakPeriodAnalysisResults {
     breakHours := 0.0
     // iterate over breaks and increment total break time
     for _, period := range(breaks) {
         if period.FinishedAt != nil {
-            log.Debug(fmt.Sprintf("adding %f hours to total breaks", period.TotalHours()))
+            logger(ctx).Debug(fmt.Sprintf("adding %f hours to total breaks", period.TotalHours()))
             breakHours += period.TotalHours()
         }
     }
-    return BreakPeriodAnalysisResults{BreakCount: len(breaks), TotalHours: breakHours}
+    return models.BreakPeriodAnalysisResults{BreakCount: len(breaks), TotalHours: breakHours}
 }
 
 // function used to analyze a list of periods (including breaks)
 // all periods are iterated over and the breaks within each period
 // are also aggregated and analyzed
-func analysePeriods(periods []WorkPeriod) AnalysisResults {
-    results := AnalysisResults{}
+func analysePeriods(ctx context.Context, periods []models.WorkPeriod) models.AnalysisResults {
+    results := models.AnalysisResults{}
     // iterate over work periods and perform analysis
     for _, period := range(periods) {
         results.TotalPeriods += 1
@@ -32,10 +34,10 @@ func analysePeriods(periods []WorkPeriod) AnalysisResults {
         if period.FinishedAt != nil {
             results.TotalWorkHours += period.TotalHours()
         }
-        log.Debug(fmt.Sprintf("breaks %+v", period.Breaks))
+        logger(ctx).Debug(fmt.Sprintf("breaks %+v", period.Breaks))
         // perform analysis on breaks and add total to results
         if len(period.Breaks) > 0 {
-            breakAnalysis := analyseBreaks(period.Breaks)
+            breakAnalysis := analyseBreaks(ctx, period.Breaks)
             results.TotalBreaks += breakAnalysis.BreakCount
             results.TotalBreakHours += breakAnalysis.TotalHours
         }
@@ -47,31 +49,31 @@ func analysePeriods(periods []WorkPeriod) AnalysisResults {
 
 // function used to analyse all user tasks. note that all history tasks
 // are analysed and returned in the response
-func analyzeUserTasks(uid string) (AnalysisResults, error) {
-    log.Info(fmt.Sprintf("performaning analysis for user %s", uid))
-    results, err := persistence.getUserData(uid)
+func analyzeUserTasks(ctx context.Context, uid string) (models.AnalysisResults, error) {
+    logger(ctx).Info(fmt.Sprintf("performaning analysis for user %s", uid))
+    results, err := persistence.getUserData(ctx, uid)
     if err != nil {
-        log.Error(fmt.Errorf("unable to get user data: %v", err))
-        return AnalysisResults{}, err
+        logger(ctx).Error(fmt.Errorf("unable to get user data: %v", err))
+        return models.AnalysisResults{}, err
     }
-    return analysePeriods(results.WorkPeriods), nil
+    return analysePeriods(ctx, results.WorkPeriods), nil
 }
 
 // function used to analyse users tasks over a period of time
-func analyseRangedUserTasks(uid string, start, end time.Time) (AnalysisResults, error) {
-    log.Info(fmt.Sprintf("performaning analysis for user %s over range %s - %s", uid, start, end))
-    results, err := persistence.getUserDataOverRange(uid, start, end)
+func analyseRangedUserTasks(ctx context.Context, uid string, start, end time.Time) (models.AnalysisResults, error) {
+    logger(ctx).Info(fmt.Sprintf("performaning analysis for user %s over range %s - %s", uid, start, end))
+    results, err := persistence.getUserDataOverRange(ctx, uid, start, end)
     if err != nil {
-        log.Error(fmt.Errorf("unable to get user data: %v", err))
-        return AnalysisResults{}, err
+        logger(ctx).Error(fmt.Errorf("unable to get user data: %v", err))
+        return models.AnalysisResults{}, err
     }
-    return analysePeriods(results.WorkPeriods), nil
+    return analysePeriods(ctx, results.WorkPeriods), nil
 }
 
 // function used to aggregate break periods by date. all periods that
 // that are created during the same day as the given date are returned
-func aggregatePeriods(periods []WorkPeriod, date time.Time) []WorkPeriod {
-    aggregate := []WorkPeriod{}
+func aggregatePeriods(periods []models.WorkPeriod, date time.Time) []models.WorkPeriod {
+    aggregate := []models.WorkPeriod{}
     for _, period := range(periods) {
         // if period is on same day as given date, add to array
         if (period.CreatedAt.After(date) && period.CreatedAt.Before(date.Add(time.Hour * 24))) {
@@ -83,8 +85,8 @@ func aggregatePeriods(periods []WorkPeriod, date time.Time) []WorkPeriod {
 
 // function used to group work periods into daily buckets. values are returned as
 // a map of {<date>: [ periods... ]}
-func groupPeriodsByDay(periods []WorkPeriod, start, end time.Time) map[string][]WorkPeriod {
-    aggregatedPeriods := map[string][]WorkPeriod{}
+func groupPeriodsByDay(periods []models.WorkPeriod, start, end time.Time) map[string][]models.WorkPeriod {
+    aggregatedPeriods := map[string][]models.WorkPeriod{}
     date := start
     for date.Before(end) {
         aggregatedPeriods[date.Format("2006-01-02")] = aggregatePeriods(periods, date)
@@ -97,16 +99,16 @@ func groupPeriodsByDay(periods []WorkPeriod, start, end time.Time) map[string][]
 // # Define functions used to bucket and analyse bucketed data
 // ###########################################################
 
-func executeBucketAnalysis(uid string, start, end time.Time, bucketSize int, includeEmpty bool) (map[time.Time]BucketAnalysis, error) {
-    log.Info(fmt.Sprintf("performaning analysis for user %s over range %s - %s", uid, start, end))
-    results, err := persistence.getUserDataOverRange(uid, start, end)
+func executeBucketAnalysis(ctx context.Context, uid string, start, end time.Time, bucketSize int, includeEmpty bool) (map[time.Time]models.BucketAnalysis, error) {
+    logger(ctx).Info(fmt.Sprintf("performaning analysis for user %s over range %s - %s", uid, start, end))
+    results, err := persistence.getUserDataOverRange(ctx, uid, start, end)
     if err != nil {
-        log.Error(fmt.Errorf("unable to get user data: %v", err))
-        return map[time.Time]BucketAnalysis{}, err
+        logger(ctx).Error(fmt.Errorf("unable to get user data: %v", err))
+        return map[time.Time]models.BucketAnalysis{}, err
     }
     // bucket periods into time ranges and execute analysis
-    bucketedPeriods := bucketPeriods(results.WorkPeriods, start, end, bucketSize)
-    return analyseBuckets(bucketedPeriods, includeEmpty), nil
+    bucketedPeriods := bucketPeriods(ctx, results.WorkPeriods, start, end, bucketSize)
+    return analyseBuckets(ctx, bucketedPeriods, includeEmpty), nil
 }
 
 // function used for safe division
@@ -118,7 +120,7 @@ func safeDivide(x, y float64) float64 {
 }
 
 // function used to aggregate results from bucket analysis
-func aggregateBuckets(buckets map[time.Time]BucketAnalysis) BucketOverview {
+func aggregateBuckets(buckets map[time.Time]models.BucketAnalysis) models.BucketOverview {
     totalWorkHours, totalBreakHours := 0.0, 0.0
     totalPeriods, totalBreaks := 0, 0
 
@@ -129,7 +131,7 @@ func aggregateBuckets(buckets map[time.Time]BucketAnalysis) BucketOverview {
         totalBreaks += analysisResults.TotalBreaks
     }
 
-    return BucketOverview{
+    return models.BucketOverview{
         BucketCount: len(buckets),
         TotalWorkHours: totalWorkHours,
         TotalBreakHours: totalBreakHours,
@@ -143,21 +145,21 @@ func aggregateBuckets(buckets map[time.Time]BucketAnalysis) BucketOverview {
 }
 
 // function used to analyse bucketed data
-func analyseBuckets(buckets map[time.Time][]WorkPeriod, includeEmpty bool) map[time.Time]BucketAnalysis {
-    results := map[time.Time]BucketAnalysis{}
+func analyseBuckets(ctx context.Context, buckets map[time.Time][]models.WorkPeriod, includeEmpty bool) map[time.Time]models.BucketAnalysis {
+    results := map[time.Time]models.BucketAnalysis{}
     for bucket, periods := range(buckets) {
-        log.Debug(fmt.Sprintf("processing bucket %+v with %d periods", bucket, len(periods)))
+        logger(ctx).Debug(fmt.Sprintf("processing bucket %+v with %d periods", bucket, len(periods)))
         if len(periods) < 1 {
             // add empty analysis if not excluding empty values
             if includeEmpty {
-                results[bucket] = BucketAnalysis{}
+                results[bucket] = models.BucketAnalysis{}
             }
             continue
         }
         // analyse periods and breaks within each bucket
-        periodAnalysis := analysePeriods(periods)
+        periodAnalysis := analysePeriods(ctx, periods)
         // create new bucket analysis instance
-        bucketAnalysis := BucketAnalysis{
+        bucketAnalysis := models.BucketAnalysis{
             TotalWorkHours: periodAnalysis.TotalWorkHours,
             TotalBreakHours: periodAnalysis.TotalBreakHours,
             NetWorkHours: periodAnalysis.NetWorkHours,
@@ -173,14 +175,14 @@ func analyseBuckets(buckets map[time.Time][]WorkPeriod, includeEmpty bool) map[t
 }
 
 // function used to determine if period falls within a given bucket
-func fallsInBucket(period WorkPeriod, date time.Time, bucket time.Duration) bool {
+func fallsInBucket(period models.WorkPeriod, date time.Time, bucket time.Duration) bool {
     return period.CreatedAt.After(date) && period.CreatedAt.Before(date.Add(bucket))
 }
 
 // function used to bucket periods around a given date
-func bucket(periods []WorkPeriod, date time.Time, bucket time.Duration) ([]WorkPeriod, []WorkPeriod) {
+func bucket(periods []models.WorkPeriod, date time.Time, bucket time.Duration) ([]models.WorkPeriod, []models.WorkPeriod) {
     // create continers for periods that fall inside and outside of time window
-    insidePeriods, outsidePeriods := []WorkPeriod{}, []WorkPeriod{}
+    insidePeriods, outsidePeriods := []models.WorkPeriod{}, []models.WorkPeriod{}
     for _, period := range(periods) {
         if fallsInBucket(period, date, bucket) {
             insidePeriods = append(insidePeriods, period)
@@ -192,9 +194,9 @@ func bucket(periods []WorkPeriod, date time.Time, bucket time.Duration) ([]WorkP
 }
 
 // function used to bucket periods by a bucket size (given in minutes)
-func bucketPeriods(periods []WorkPeriod, start, end time.Time, bucketSize int) map[time.Time][]WorkPeriod {
-    log.Debug(fmt.Sprintf("bucketing periods over date range %s - %s with bucket %d", start, end, bucketSize))
-    bucketed := map[time.Time][]WorkPeriod{}
+func bucketPeriods(ctx context.Context, periods []models.WorkPeriod, start, end time.Time, bucketSize int) map[time.Time][]models.WorkPeriod {
+    logger(ctx).Debug(fmt.Sprintf("bucketing periods over date range %s - %s with bucket %d", start, end, bucketSize))
+    bucketed := map[time.Time][]models.WorkPeriod{}
     // evaluate bucket duration as time.Duration instance
     bucketDuration := time.Minute * time.Duration(bucketSize)
     for start.Before(end) {
@@ -206,4 +208,68 @@ func bucketPeriods(periods []WorkPeriod, start, end time.Time, bucketSize int) m
         periods = outside
     }
     return bucketed
+}
+
+// ###########################################################
+// # Define functions used to analyse data for teams of users
+// ###########################################################
+
+// function used to retrieve data for a list of users over a given
+// time range. data is returned as a map of {<uid>: [ periods... ]}
+func getTeamDataOverRange(ctx context.Context, uids []string, start, end time.Time) (map[string][]models.WorkPeriod, error) {
+    data := map[string][]models.WorkPeriod{}
+    for _, uid := range(uids) {
+        results, err := persistence.getUserDataOverRange(ctx, uid, start, end)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to get user data for user %s: %v", uid, err))
+            return map[string][]models.WorkPeriod{}, err
+        }
+        data[uid] = results.WorkPeriods
+    }
+    return data, nil
+}
+
+// function used to combine periods from multiple users into a single
+// list of periods sorted by creation time
+func combinePeriods(data map[string][]models.WorkPeriod) []models.WorkPeriod {
+    combined := []models.WorkPeriod{}
+    for _, periods := range(data) {
+        combined = append(combined, periods...)
+    }
+    sort.Slice(combined, func(i, j int) bool {
+        return combined[i].CreatedAt.Before(combined[j].CreatedAt)
+    })
+    return combined
+}
+
+// function used to analyse tasks for a list of users over a period of
+// time. results are returned per user along with the aggregate results
+func analyseRangedTeamTasks(ctx context.Context, uids []string, start, end time.Time) (models.TeamAnalysisResults, error) {
+    logger(ctx).Info(fmt.Sprintf("performaning analysis for %d users over range %s - %s", len(uids), start, end))
+    data, err := getTeamDataOverRange(ctx, uids, start, end)
+    if err != nil {
+        return models.TeamAnalysisResults{}, err
+    }
+    results := models.TeamAnalysisResults{Members: map[string]models.AnalysisResults{}}
+    for uid, periods := range(data) {
+        results.Members[uid] = analysePeriods(ctx, periods)
+    }
+    results.Aggregate = analysePeriods(ctx, combinePeriods(data))
+    return results, nil
+}
+
+// function used to execute bucket analysis for a list of users. buckets are
+// returned per user along with buckets containing the periods of all users
+func executeTeamBucketAnalysis(ctx context.Context, uids []string, start, end time.Time, bucketSize int, includeEmpty bool) (map[string]map[time.Time]models.BucketAnalysis, map[time.Time]models.BucketAnalysis, error) {
+    logger(ctx).Info(fmt.Sprintf("performaning bucket analysis for %d users over range %s - %s", len(uids), start, end))
+    data, err := getTeamDataOverRange(ctx, uids, start, end)
+    if err != nil {
+        return map[string]map[time.Time]models.BucketAnalysis{}, map[time.Time]models.BucketAnalysis{}, err
+    }
+    members := map[string]map[time.Time]models.BucketAnalysis{}
+    for uid, periods := range(data) {
+        members[uid] = analyseBuckets(ctx, bucketPeriods(ctx, periods, start, end, bucketSize), includeEmpty)
+    }
+    aggregate := analyseBuckets(ctx, bucketPeriods(ctx, combinePeriods(data), start, end, bucketSize), includeEmpty)
+    return members, aggregate, nil
 }
\ No newline at end of file
@@ -0,0 +1,496 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "errors"
+    "context"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    TimesheetOpen = "open"
+    TimesheetSubmitted = "submitted"
+    TimesheetApproved = "approved"
+    TimesheetRejected = "rejected"
+
+    ErrTimesheetNotPending = errors.New("timesheet is not pending approval")
+    ErrWeekLocked = errors.New("timesheet week is locked")
+    ErrTimesheetSubmitted = errors.New("timesheet already submitted")
+    ErrActivePeriodInWeek = errors.New("timesheet week contains active work period")
+)
+
+// function used to evaluate the start of the week (monday at midnight UTC)
+// that a given timestamp falls into. timesheets are always submitted
+// and approved on a weekly basis
+func weekStart(t time.Time) time.Time {
+    t = t.UTC()
+    offset := (int(t.Weekday()) + 6) % 7
+    year, month, day := t.AddDate(0, 0, -offset).Date()
+    return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// function used to parse week from URL parameter. weeks can be given
+// as any date in YYYY-MM-DD format and are normalized to the start of
+// the week that the date falls into
+func parseWeek(value string) (time.Time, error) {
+    date, err := time.Parse("2006-01-02", value)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse week '%s': %v", value, err))
+        return time.Time{}, err
+    }
+    return weekStart(date), nil
+}
+
+// function used to check that a given week has not been approved using
+// the given transaction. the user is locked for the duration of the
+// transaction, meaning that the week cannot be approved until the
+// transaction has been committed. ErrWeekLocked is returned for
+// approved weeks
+func lockTimesheetWeek(ctx context.Context, tx pgx.Tx, uid string, week time.Time) error {
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock user %s: %v", uid, err))
+        return err
+    }
+    var status string
+    err := tx.QueryRow(ctx, "SELECT status FROM timesheet_submissions WHERE uid=$1 AND week_start=$2", uid, week).Scan(&status)
+    if err != nil && err != pgx.ErrNoRows {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve timesheet status: %v", err))
+        return err
+    }
+    if status == TimesheetApproved {
+        return ErrWeekLocked
+    }
+    return nil
+}
+
+// function used to retrieve timesheet submission for a given user and
+// week. weeks that have not been submitted are returned with open status
+func getTimesheetStatus(ctx context.Context, uid string, week time.Time) (models.TimesheetSubmission, error) {
+    submission, err := persistence.getTimesheetSubmission(ctx, uid, week)
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            return models.TimesheetSubmission{Uid: uid, WeekStart: week, Status: TimesheetOpen}, nil
+        default:
+            return models.TimesheetSubmission{}, err
+        }
+    }
+    return submission, nil
+}
+
+// function used to retrieve all timesheet submissions for current user
+func listTimesheetsHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to list timesheets for user %s", user))
+    submissions, err := persistence.getTimesheetSubmissions(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve timesheets for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": submissions})
+}
+
+// function used to retrieve timesheet status for current user and week
+func getTimesheetHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    week, err := parseWeek(ctx.Param("week"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid week")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to get timesheet for user %s and week %s", user, week))
+    submission, err := getTimesheetStatus(ctx.Request.Context(), user, week)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve timesheet for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": submission})
+}
+
+// function used to submit a weekly timesheet for approval. weeks can
+// be submitted if they are open or have previously been rejected. weeks
+// in the future and weeks with unfinished work periods cannot be submitted
+func submitTimesheetHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    week, err := parseWeek(ctx.Param("week"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid week")
+        return
+    }
+    if week.After(weekStart(time.Now())) {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "cannot submit future week")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to submit timesheet for user %s and week %s", user, week))
+    submission, err := persistence.submitTimesheet(ctx.Request.Context(), user, week)
+    if err != nil {
+        switch err {
+        case ErrTimesheetSubmitted:
+            StandardHTTP.ConflictWithMessage(ctx, "timesheet already submitted or approved")
+        case ErrActivePeriodInWeek:
+            StandardHTTP.ConflictWithMessage(ctx, "timesheet week contains active work period")
+        default:
+            log.Error(fmt.Errorf("unable to submit timesheet for user %s: %v", user, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": submission})
+}
+
+// function used to list timesheet submissions for all users managed by the
+// current user. submissions can be filtered using the status query parameter
+func listApprovalsHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    global := hasRole(ctx, RoleAdmin) || isGlobalManager(user)
+    // retrieve users managed by current user. admins and global managers can see all users
+    managed, err := persistence.getManagedUsers(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve users managed by %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    if !global && len(managed) == 0 {
+        log.Warn(fmt.Sprintf("user %s attempted to list approvals without manager permissions", user))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    status := ctx.DefaultQuery("status", TimesheetSubmitted)
+    log.Debug(fmt.Sprintf("received request to list timesheets with status %s", status))
+    submissions, err := persistence.getTimesheetSubmissionsByStatus(ctx.Request.Context(), status)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve timesheets with status %s: %v", status, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    if !global {
+        submissions = filterTimesheetSubmissions(submissions, managed)
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": submissions})
+}
+
+// function used to filter timesheet submissions to a given list of users
+func filterTimesheetSubmissions(submissions []models.TimesheetSubmission, uids []string) []models.TimesheetSubmission {
+    allowed := map[string]bool{}
+    for _, uid := range(uids) {
+        allowed[uid] = true
+    }
+    filtered := []models.TimesheetSubmission{}
+    for _, submission := range(submissions) {
+        if allowed[submission.Uid] {
+            filtered = append(filtered, submission)
+        }
+    }
+    return filtered
+}
+
+// function used to ensure that the current user is allowed to see and
+// review timesheets for the user given in the URL parameters. the
+// appropriate response is written to the context if not
+func authorizeReviewer(ctx *gin.Context) (string, bool) {
+    user, member := getUser(ctx), ctx.Param("uid")
+    allowed, err := canManageUser(ctx, member)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to evaluate permissions of user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return member, false
+    }
+    if !allowed {
+        log.Warn(fmt.Sprintf("user %s attempted to access timesheets of user %s without manager permissions", user, member))
+        StandardHTTP.Forbidden(ctx)
+        return member, false
+    }
+    return member, true
+}
+
+// function used to list all timesheet submissions for a given user
+func listUserApprovalsHandler(ctx *gin.Context) {
+    member, ok := authorizeReviewer(ctx)
+    if !ok {
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to list timesheets for user %s", member))
+    submissions, err := persistence.getTimesheetSubmissions(ctx.Request.Context(), member)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve timesheets for user %s: %v", member, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": submissions})
+}
+
+// function used to retrieve timesheet status for a given user and
+// week. the analysis results for the week are returned alongside
+// the submission so that managers can review the timesheet
+func getApprovalHandler(ctx *gin.Context) {
+    member, ok := authorizeReviewer(ctx)
+    if !ok {
+        return
+    }
+    week, err := parseWeek(ctx.Param("week"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid week")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to get timesheet for user %s and week %s", member, week))
+    submission, err := getTimesheetStatus(ctx.Request.Context(), member, week)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve timesheet for user %s: %v", member, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    results, err := analyseRangedUserTasks(ctx.Request.Context(), member, week, week.AddDate(0, 0, 7))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to analyse user tasks: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    payload := gin.H{
+        "timesheet": submission,
+        "results": results,
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
+}
+
+// function used to approve a submitted timesheet
+func approveTimesheetHandler(ctx *gin.Context) {
+    reviewTimesheet(ctx, TimesheetApproved)
+}
+
+// function used to reject a submitted timesheet. note that a
+// comment must be provided when rejecting a timesheet
+func rejectTimesheetHandler(ctx *gin.Context) {
+    reviewTimesheet(ctx, TimesheetRejected)
+}
+
+// function used to review a timesheet submission. only managers of the
+// user are allowed to review timesheets and users cannot review their own
+func reviewTimesheet(ctx *gin.Context, status string) {
+    member, ok := authorizeReviewer(ctx)
+    if !ok {
+        return
+    }
+    user := getUser(ctx)
+    if user == member {
+        log.Warn(fmt.Sprintf("user %s attempted to review own timesheet", user))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    week, err := parseWeek(ctx.Param("week"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid week")
+        return
+    }
+    var request models.TimesheetReviewRequest
+    if ctx.Request.ContentLength > 0 {
+        if err := ctx.ShouldBindJSON(&request); err != nil {
+            log.Error(fmt.Errorf("received invalid review request: %v", err))
+            StandardHTTP.InvalidJSON(ctx)
+            return
+        }
+    }
+    if status == TimesheetRejected && len(request.Comment) == 0 {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "comment required when rejecting timesheet")
+        return
+    }
+
+    log.Debug(fmt.Sprintf("received request to mark timesheet for user %s and week %s as %s", member, week, status))
+    err = persistence.reviewTimesheet(ctx.Request.Context(), member, week, user, status, request.Comment)
+    if err != nil {
+        switch err {
+        case ErrTimesheetNotPending:
+            StandardHTTP.ConflictWithMessage(ctx, "timesheet is not pending approval")
+        case ErrActivePeriodInWeek:
+            StandardHTTP.ConflictWithMessage(ctx, "timesheet week contains active work period")
+        default:
+            log.Error(fmt.Errorf("unable to review timesheet for user %s: %v", member, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully %s timesheet", status)})
+}
+
+// ###########################################################
+// # Define persistence functions used to store timesheet approvals
+// ###########################################################
+
+// function used to scan timesheet submissions from query results
+func scanTimesheetSubmissions(rows pgx.Rows) ([]models.TimesheetSubmission, error) {
+    defer rows.Close()
+    submissions := []models.TimesheetSubmission{}
+    for rows.Next() {
+        var submission models.TimesheetSubmission
+        err := rows.Scan(&submission.Uid, &submission.WeekStart, &submission.Status, &submission.SubmittedAt,
+            &submission.ReviewedBy, &submission.ReviewedAt, &submission.Comment)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to process timesheet submission: %v", err))
+            return submissions, err
+        }
+        submissions = append(submissions, submission)
+    }
+    return submissions, rows.Err()
+}
+
+// function used to retrieve timesheet submission for user and week
+func(db Persistence) getTimesheetSubmission(ctx context.Context, uid string, week time.Time) (models.TimesheetSubmission, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving timesheet for user %s and week %s", uid, week))
+    var submission models.TimesheetSubmission
+    result := db.conn.QueryRow(ctx, "SELECT uid,week_start,status,submitted_at,reviewed_by,reviewed_at,comment FROM timesheet_submissions WHERE uid=$1 AND week_start=$2", uid, week)
+    err := result.Scan(&submission.Uid, &submission.WeekStart, &submission.Status, &submission.SubmittedAt,
+        &submission.ReviewedBy, &submission.ReviewedAt, &submission.Comment)
+    if err != nil {
+        return models.TimesheetSubmission{}, err
+    }
+    return submission, nil
+}
+
+// function used to retrieve all timesheet submissions for a user
+func(db Persistence) getTimesheetSubmissions(ctx context.Context, uid string) ([]models.TimesheetSubmission, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving timesheets for user %s", uid))
+    rows, err := db.conn.Query(ctx, "SELECT uid,week_start,status,submitted_at,reviewed_by,reviewed_at,comment FROM timesheet_submissions WHERE uid=$1 ORDER BY week_start DESC", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve timesheets for user %s: %v", uid, err))
+        return []models.TimesheetSubmission{}, err
+    }
+    return scanTimesheetSubmissions(rows)
+}
+
+// function used to retrieve all timesheet submissions with a given status
+func(db Persistence) getTimesheetSubmissionsByStatus(ctx context.Context, status string) ([]models.TimesheetSubmission, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving timesheets with status %s", status))
+    rows, err := db.conn.Query(ctx, "SELECT uid,week_start,status,submitted_at,reviewed_by,reviewed_at,comment FROM timesheet_submissions WHERE status=$1 ORDER BY week_start DESC", status)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve timesheets with status %s: %v", status, err))
+        return []models.TimesheetSubmission{}, err
+    }
+    return scanTimesheetSubmissions(rows)
+}
+
+// function used to submit timesheet for approval. previously rejected
+// submissions are reset and resubmitted. the user is locked while the
+// submission is evaluated so that the week cannot be reviewed at the same
+// time. ErrTimesheetSubmitted is returned for weeks that are already
+// submitted or approved and ErrActivePeriodInWeek if the week still
+// contains an unfinished work period
+func(db Persistence) submitTimesheet(ctx context.Context, uid string, week time.Time) (models.TimesheetSubmission, error) {
+    logger(ctx).Debug(fmt.Sprintf("submitting timesheet for user %s and week %s", uid, week))
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return models.TimesheetSubmission{}, err
+    }
+    defer tx.Rollback(ctx)
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock user %s: %v", uid, err))
+        return models.TimesheetSubmission{}, err
+    }
+    if err := checkActivePeriodInWeek(ctx, tx, uid, week); err != nil {
+        return models.TimesheetSubmission{}, err
+    }
+
+    now := time.Now()
+    result, err := tx.Exec(ctx, `INSERT INTO timesheet_submissions(uid, week_start, status, submitted_at) VALUES($1,$2,$3,$4)
+        ON CONFLICT (uid, week_start) DO UPDATE SET status=$3, submitted_at=$4, reviewed_by=NULL, reviewed_at=NULL, comment=NULL
+        WHERE timesheet_submissions.status=$5`, uid, week, TimesheetSubmitted, now, TimesheetRejected)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to submit timesheet: %v", err))
+        return models.TimesheetSubmission{}, err
+    }
+    if result.RowsAffected() == 0 {
+        return models.TimesheetSubmission{}, ErrTimesheetSubmitted
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit timesheet submission: %v", err))
+        return models.TimesheetSubmission{}, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully submitted timesheet for user %s and week %s", uid, week))
+    return models.TimesheetSubmission{Uid: uid, WeekStart: week, Status: TimesheetSubmitted, SubmittedAt: &now}, nil
+}
+
+// function used to ensure that a given week does not contain any unfinished
+// work periods. approved weeks are locked, meaning that unfinished periods
+// could otherwise never be closed. ErrActivePeriodInWeek is returned if
+// an unfinished period exists
+func checkActivePeriodInWeek(ctx context.Context, tx pgx.Tx, uid string, week time.Time) error {
+    var active bool
+    err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM work_periods WHERE uid=$1 AND finished_at IS NULL AND created_at >= $2 AND created_at < $3)",
+        uid, week, week.AddDate(0, 0, 7)).Scan(&active)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to check for active work periods: %v", err))
+        return err
+    }
+    if active {
+        return ErrActivePeriodInWeek
+    }
+    return nil
+}
+
+// function used to approve or reject timesheet submission. only
+// submissions that are currently pending can be reviewed
+func(db Persistence) reviewTimesheet(ctx context.Context, uid string, week time.Time, reviewer, status, comment string) error {
+    logger(ctx).Debug(fmt.Sprintf("marking timesheet for user %s and week %s as %s", uid, week, status))
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    defer tx.Rollback(ctx)
+    // lock user so that weeks are not approved while periods are updated
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock user %s: %v", uid, err))
+        return err
+    }
+    if status == TimesheetApproved {
+        if err := checkActivePeriodInWeek(ctx, tx, uid, week); err != nil {
+            return err
+        }
+    }
+    result, err := tx.Exec(ctx, "UPDATE timesheet_submissions SET status=$1, reviewed_by=$2, reviewed_at=$3, comment=NULLIF($4, '') WHERE uid=$5 AND week_start=$6 AND status=$7",
+        status, reviewer, time.Now(), comment, uid, week, TimesheetSubmitted)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to review timesheet: %v", err))
+        return err
+    }
+    if result.RowsAffected() == 0 {
+        return ErrTimesheetNotPending
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit timesheet review: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully marked timesheet for user %s and week %s as %s", uid, week, status))
+    return nil
+}
+
+// function used to retrieve owner and creation time of work period
+func(db Persistence) getWorkPeriodOwner(ctx context.Context, periodId uuid.UUID) (string, time.Time, error) {
+    var (uid string; createdAt time.Time)
+    result := db.conn.QueryRow(ctx, "SELECT uid,created_at FROM work_periods WHERE period_id=$1", periodId)
+    err := result.Scan(&uid, &createdAt)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve owner of work period %s: %v", periodId, err))
+        return uid, createdAt, err
+    }
+    return uid, createdAt, nil
+}
+
+// function used to retrieve owner and creation time of the work
+// period that a given break period belongs to
+func(db Persistence) getBreakPeriodOwner(ctx context.Context, breakId uuid.UUID) (string, time.Time, error) {
+    var (uid string; createdAt time.Time)
+    result := db.conn.QueryRow(ctx, "SELECT w.uid,w.created_at FROM break_periods b JOIN work_periods w ON b.period_id=w.period_id WHERE b.break_id=$1", breakId)
+    err := result.Scan(&uid, &createdAt)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve owner of break period %s: %v", breakId, err))
+        return uid, createdAt, err
+    }
+    return uid, createdAt, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+    "fmt"
+    "context"
+    "strings"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    RoleUser = "user"
+    RoleManager = "manager"
+    RoleAdmin = "admin"
+
+    // define value used to disable default roles
+    NoDefaultRoles = "none"
+
+    // define roles that are implicitly granted by other roles
+    roleHierarchy = map[string][]string{
+        RoleUser: []string{RoleUser},
+        RoleManager: []string{RoleManager, RoleUser},
+        RoleAdmin: []string{RoleAdmin, RoleManager, RoleUser},
+    }
+
+    roleResolvers = map[string]RoleResolver{
+        "config": ConfigRoleResolver{},
+        "header": HeaderRoleResolver{},
+        "database": DatabaseRoleResolver{},
+        "token": TokenRoleResolver{},
+    }
+)
+
+// define interface used to resolve the roles of an authenticated user
+type RoleResolver interface {
+    ResolveRoles(ctx *gin.Context, uid string) ([]string, error)
+}
+
+// define resolver used to assign roles from lists of users in the service config.
+// all users are additionally assigned the configured default roles
+type ConfigRoleResolver struct{}
+
+func(resolver ConfigRoleResolver) ResolveRoles(ctx *gin.Context, uid string) ([]string, error) {
+    roles := []string{}
+    for _, user := range(UserList) {
+        if user == uid {
+            roles = append(roles, RoleUser)
+        }
+    }
+    for _, admin := range(AdminUsers) {
+        if admin == uid {
+            roles = append(roles, RoleAdmin)
+        }
+    }
+    for _, manager := range(ManagerUsers) {
+        if manager == uid {
+            roles = append(roles, RoleManager)
+        }
+    }
+    return append(roles, DefaultRoles...), nil
+}
+
+// define resolver used to read roles from a comma separated header set
+// by an upstream gateway, along with the authenticated user ID header
+type HeaderRoleResolver struct{}
+
+func(resolver HeaderRoleResolver) ResolveRoles(ctx *gin.Context, uid string) ([]string, error) {
+    roles := []string{}
+    for _, role := range(strings.Split(ctx.Request.Header.Get(RolesHeader), ",")) {
+        if role = strings.TrimSpace(role); len(role) > 0 {
+            roles = append(roles, role)
+        }
+    }
+    return roles, nil
+}
+
+// define resolver used to read roles from the user_roles table
+type DatabaseRoleResolver struct{}
+
+func(resolver DatabaseRoleResolver) ResolveRoles(ctx *gin.Context, uid string) ([]string, error) {
+    roles, err := persistence.getUserRoles(ctx.Request.Context(), uid)
+    if err != nil {
+        return roles, err
+    }
+    return append(roles, DefaultRoles...), nil
+}
+
+// function used to determine if a user is a globally configured manager.
+// global managers are allowed to review timesheets for all users
+func isGlobalManager(uid string) bool {
+    for _, manager := range(ManagerUsers) {
+        if manager == uid {
+            return true
+        }
+    }
+    return false
+}
+
+// function used to determine if a role is known to the service
+func isValidRole(role string) bool {
+    _, ok := roleHierarchy[role]
+    return ok
+}
+
+// function used to expand list of roles to include all implicitly
+// granted roles. unknown roles are discarded
+func expandRoles(roles []string) []string {
+    expanded, seen := []string{}, map[string]bool{}
+    for _, role := range(roles) {
+        for _, granted := range(roleHierarchy[role]) {
+            if !seen[granted] {
+                seen[granted] = true
+                expanded = append(expanded, granted)
+            }
+        }
+    }
+    return expanded
+}
+
+// function used to retrieve roles of authenticated user from request context.
+// note that roles are only set for routes protected by the authorize middleware
+func getRoles(ctx *gin.Context) []string {
+    if roles, ok := ctx.Get("roles"); ok {
+        return roles.([]string)
+    }
+    return []string{}
+}
+
+// function used to determine if authenticated user holds a given role
+func hasRole(ctx *gin.Context, role string) bool {
+    for _, granted := range(getRoles(ctx)) {
+        if granted == role {
+            return true
+        }
+    }
+    return false
+}
+
+// middleware used to authorize requests. the roles of the authenticated user
+// are resolved using the configured role source, and requests are rejected
+// with a 401 if no user is present or 403 if the user holds none of the roles.
+// requests authenticated with API tokens must also hold the required scopes
+func authorize(roles ...string) gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        user := getUser(ctx)
+        if len(user) == 0 {
+            log.Warn(fmt.Sprintf("received unauthenticated request for route %s", ctx.FullPath()))
+            StandardHTTP.Unauthorized(ctx)
+            return
+        }
+        resolved, err := roleResolvers[RoleSource].ResolveRoles(ctx, user)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to resolve roles for user %s: %v", user, err))
+            StandardHTTP.InternalServerError(ctx)
+            return
+        }
+        granted := expandRoles(resolved)
+        if len(granted) == 0 {
+            log.Warn(fmt.Sprintf("received request from unknown user %s", user))
+            StandardHTTP.Forbidden(ctx)
+            return
+        }
+        ctx.Set("roles", granted)
+
+        // ensure that API tokens used to authenticate request have required scopes
+        if !hasTokenScope(ctx) {
+            log.Warn(fmt.Sprintf("user %s attempted to access route %s with insufficient token scopes", user, ctx.FullPath()))
+            StandardHTTP.Forbidden(ctx)
+            return
+        }
+        for _, role := range(roles) {
+            if hasRole(ctx, role) {
+                ctx.Next()
+                return
+            }
+        }
+        log.Warn(fmt.Sprintf("user %s with roles %v attempted to access route %s", user, granted, ctx.FullPath()))
+        StandardHTTP.Forbidden(ctx)
+    }
+}
+
+// function used to retrieve roles of current user
+func getRolesHandler(ctx *gin.Context) {
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": gin.H{"uid": getUser(ctx), "roles": getRoles(ctx)}})
+}
+
+// function used to retrieve roles stored in the database for a given user
+func getUserRolesHandler(ctx *gin.Context) {
+    uid := ctx.Param("uid")
+    log.Debug(fmt.Sprintf("received request to get roles for user %s", uid))
+    roles, err := persistence.getUserRoles(ctx.Request.Context(), uid)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve roles for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": gin.H{"uid": uid, "roles": roles}})
+}
+
+// function used to replace the roles stored in the database for a given
+// user. note that stored roles are only used with the database role source
+func setUserRolesHandler(ctx *gin.Context) {
+    uid := ctx.Param("uid")
+    var request models.UserRolesRequest
+    if err := ctx.ShouldBindJSON(&request); err != nil {
+        log.Error(fmt.Errorf("received invalid roles request: %v", err))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    for _, role := range(request.Roles) {
+        if !isValidRole(role) {
+            StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("invalid role %s", role))
+            return
+        }
+    }
+    log.Debug(fmt.Sprintf("received request to set roles for user %s to %v", uid, request.Roles))
+    if err := persistence.setUserRoles(ctx.Request.Context(), uid, request.Roles); err != nil {
+        log.Error(fmt.Errorf("unable to set roles for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully updated roles for user %s", uid)})
+}
+
+// ###########################################################
+// # Define persistence functions used to store user roles
+// ###########################################################
+
+// function used to retrieve all roles stored for a given user
+func(db Persistence) getUserRoles(ctx context.Context, uid string) ([]string, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving roles for user %s", uid))
+    roles := []string{}
+    rows, err := db.conn.Query(ctx, "SELECT role FROM user_roles WHERE uid=$1", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve roles for user %s: %v", uid, err))
+        switch err {
+        case pgx.ErrNoRows:
+            return roles, nil
+        default:
+            return roles, err
+        }
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var role string
+        if err := rows.Scan(&role); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process user role: %v", err))
+            return roles, err
+        }
+        roles = append(roles, role)
+    }
+    return roles, rows.Err()
+}
+
+// function used to replace all roles stored for a given user. existing
+// roles are removed and new roles inserted within a single transaction
+func(db Persistence) setUserRoles(ctx context.Context, uid string, roles []string) error {
+    logger(ctx).Debug(fmt.Sprintf("setting roles for user %s", uid))
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, "DELETE FROM user_roles WHERE uid=$1", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to remove user roles: %v", err))
+        return err
+    }
+    for _, role := range(roles) {
+        _, err := tx.Exec(ctx, "INSERT INTO user_roles(uid, role) VALUES($1,$2) ON CONFLICT DO NOTHING", uid, role)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to insert user role: %v", err))
+            return err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit user roles: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully set roles for user %s", uid))
+    return nil
+}
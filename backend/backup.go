@@ -0,0 +1,249 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "time"
+    "bufio"
+    "context"
+    "encoding/json"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    BackupVersion = 1
+
+    BackupHeader = "header"
+    BackupWorkPeriod = "work_period"
+    BackupBreakPeriod = "break_period"
+    BackupTrailer = "trailer"
+)
+
+// define error type used for backups that cannot be decoded or contain
+// invalid records, allowing them to be distinguished from database errors
+type InvalidBackupError struct {
+    message string
+}
+
+func(err InvalidBackupError) Error() string {
+    return err.message
+}
+
+// function used to create new invalid backup error
+func invalidBackup(format string, args ...interface{}) error {
+    return InvalidBackupError{message: fmt.Sprintf(format, args...)}
+}
+
+// function used to write instance-wide backup in JSON lines format. the
+// backup starts with a header record, followed by all work periods and
+// then all break periods, so that break periods can always be restored
+// after the work periods they belong to. both tables are read from the
+// same snapshot so that the backup is consistent. the backup ends with a
+// trailer record containing the number of periods written, allowing
+// truncated backups to be detected when restoring
+func(db Persistence) writeBackup(ctx context.Context, writer io.Writer) (models.RestoreSummary, error) {
+    logger(ctx).Info("writing instance backup")
+    summary := models.RestoreSummary{}
+    tx, err := db.conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return summary, err
+    }
+    defer tx.Rollback(ctx)
+    encoder := json.NewEncoder(writer)
+    if err := encoder.Encode(models.BackupRecord{Type: BackupHeader, Version: BackupVersion, CreatedAt: time.Now().UTC()}); err != nil {
+        return summary, err
+    }
+
+    rows, err := tx.Query(ctx, "SELECT period_id,uid,created_at,finished_at FROM work_periods ORDER BY created_at")
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve work periods: %v", err))
+        return summary, err
+    }
+    for rows.Next() {
+        record := models.BackupRecord{Type: BackupWorkPeriod}
+        if err := rows.Scan(&record.PeriodId, &record.Uid, &record.CreatedAt, &record.FinishedAt); err != nil {
+            rows.Close()
+            return summary, err
+        }
+        if err := encoder.Encode(record); err != nil {
+            rows.Close()
+            return summary, err
+        }
+        summary.WorkPeriods++
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return summary, err
+    }
+
+    rows, err = tx.Query(ctx, "SELECT break_id,period_id,created_at,finished_at FROM break_periods ORDER BY created_at")
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break periods: %v", err))
+        return summary, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        record := models.BackupRecord{Type: BackupBreakPeriod}
+        if err := rows.Scan(&record.BreakId, &record.PeriodId, &record.CreatedAt, &record.FinishedAt); err != nil {
+            return summary, err
+        }
+        if err := encoder.Encode(record); err != nil {
+            return summary, err
+        }
+        summary.BreakPeriods++
+    }
+    if err := rows.Err(); err != nil {
+        return summary, err
+    }
+    if err := encoder.Encode(models.BackupRecord{Type: BackupTrailer, CreatedAt: time.Now().UTC(), Counts: &summary}); err != nil {
+        return summary, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully wrote backup with %d work periods and %d break periods", summary.WorkPeriods, summary.BreakPeriods))
+    return summary, nil
+}
+
+// function used to restore instance from JSON lines backup. all records are
+// upserted within a single transaction, meaning that restoring the same
+// backup multiple times always results in the same database state. events
+// are recorded for periods that are created or changed by the restore. backups
+// without a trailer record or with counts that do not match the restored
+// records are rejected as truncated
+func(db Persistence) restoreBackup(ctx context.Context, reader io.Reader) (models.RestoreSummary, error) {
+    logger(ctx).Info("restoring instance backup")
+    summary := models.RestoreSummary{}
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return summary, err
+    }
+    defer tx.Rollback(ctx)
+
+    var trailer *models.RestoreSummary
+    scanner := bufio.NewScanner(reader)
+    scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+    for line := 1; scanner.Scan(); line++ {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        var record models.BackupRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return summary, invalidBackup("invalid record on line %d: %v", line, err)
+        }
+        if trailer != nil {
+            return summary, invalidBackup("unexpected record after trailer on line %d", line)
+        }
+        switch record.Type {
+        case BackupHeader:
+            if record.Version != BackupVersion {
+                return summary, invalidBackup("unsupported backup version %d", record.Version)
+            }
+        case BackupWorkPeriod:
+            if record.PeriodId == nil || len(record.Uid) == 0 {
+                return summary, invalidBackup("invalid work period on line %d", line)
+            }
+            // unchanged periods are skipped so that no events are recorded for them
+            var inserted bool
+            err := tx.QueryRow(ctx, `INSERT INTO work_periods(period_id, uid, created_at, finished_at) VALUES($1,$2,$3,$4)
+                ON CONFLICT (period_id) DO UPDATE SET uid=$2, created_at=$3, finished_at=$4
+                WHERE (work_periods.uid, work_periods.created_at, work_periods.finished_at) IS DISTINCT FROM (EXCLUDED.uid, EXCLUDED.created_at, EXCLUDED.finished_at)
+                RETURNING xmax=0`, record.PeriodId, record.Uid, record.CreatedAt, record.FinishedAt).Scan(&inserted)
+            switch err {
+            case nil:
+                period := models.WorkPeriod{PeriodId: *record.PeriodId, CreatedAt: record.CreatedAt, FinishedAt: record.FinishedAt, Breaks: []models.BreakPeriod{}}
+                if inserted {
+                    err = recordWrittenWorkPeriodEvents(ctx, tx, record.Uid, period)
+                } else {
+                    _, err = recordPeriodEvent(ctx, tx, record.Uid, EventWorkPeriodUpdated, period.PeriodId, nil, period)
+                }
+                if err != nil {
+                    return summary, fmt.Errorf("unable to record events for work period on line %d: %v", line, err)
+                }
+            case pgx.ErrNoRows:
+            default:
+                return summary, fmt.Errorf("unable to restore work period on line %d: %v", line, err)
+            }
+            summary.WorkPeriods++
+        case BackupBreakPeriod:
+            if record.BreakId == nil || record.PeriodId == nil {
+                return summary, invalidBackup("invalid break period on line %d", line)
+            }
+            var (inserted bool; uid string)
+            err := tx.QueryRow(ctx, `INSERT INTO break_periods(break_id, period_id, created_at, finished_at) VALUES($1,$2,$3,$4)
+                ON CONFLICT (break_id) DO UPDATE SET period_id=$2, created_at=$3, finished_at=$4
+                WHERE (break_periods.period_id, break_periods.created_at, break_periods.finished_at) IS DISTINCT FROM (EXCLUDED.period_id, EXCLUDED.created_at, EXCLUDED.finished_at)
+                RETURNING xmax=0, (SELECT uid FROM work_periods WHERE period_id=$2)`, record.BreakId, record.PeriodId, record.CreatedAt, record.FinishedAt).Scan(&inserted, &uid)
+            switch err {
+            case nil:
+                breakPeriod := models.BreakPeriod{BreakId: *record.BreakId, CreatedAt: record.CreatedAt, FinishedAt: record.FinishedAt}
+                if inserted {
+                    err = recordWrittenBreakPeriodEvents(ctx, tx, uid, *record.PeriodId, breakPeriod)
+                } else {
+                    _, err = recordPeriodEvent(ctx, tx, uid, EventBreakPeriodUpdated, *record.PeriodId, record.BreakId, breakPeriod)
+                }
+                if err != nil {
+                    return summary, fmt.Errorf("unable to record events for break period on line %d: %v", line, err)
+                }
+            case pgx.ErrNoRows:
+            default:
+                return summary, fmt.Errorf("unable to restore break period on line %d: %v", line, err)
+            }
+            summary.BreakPeriods++
+        case BackupTrailer:
+            if record.Counts == nil {
+                return summary, invalidBackup("invalid trailer on line %d", line)
+            }
+            trailer = record.Counts
+        default:
+            return summary, invalidBackup("unknown record type '%s' on line %d", record.Type, line)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return summary, invalidBackup("unable to read backup: %v", err)
+    }
+    if trailer == nil {
+        return summary, invalidBackup("backup is incomplete: missing trailer record")
+    }
+    if *trailer != summary {
+        return summary, invalidBackup("backup is incomplete: expected %d work periods and %d break periods, got %d and %d",
+            trailer.WorkPeriods, trailer.BreakPeriods, summary.WorkPeriods, summary.BreakPeriods)
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit restore: %v", err))
+        return summary, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully restored %d work periods and %d break periods", summary.WorkPeriods, summary.BreakPeriods))
+    return summary, nil
+}
+
+// function used to stream instance-wide backup to client
+func getBackupHandler(ctx *gin.Context) {
+    log.Debug(fmt.Sprintf("received request to create backup from user %s", getUser(ctx)))
+    ctx.Header("Content-Type", "application/x-ndjson")
+    ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=go_timesheets_%s.jsonl", time.Now().UTC().Format("20060102T150405")))
+    ctx.Status(200)
+    // note that errors cannot be returned to the client once streaming has started
+    if _, err := persistence.writeBackup(ctx.Request.Context(), ctx.Writer); err != nil {
+        log.Error(fmt.Errorf("unable to write backup: %v", err))
+    }
+}
+
+// function used to restore instance from backup sent as request body
+func restoreBackupHandler(ctx *gin.Context) {
+    log.Debug(fmt.Sprintf("received request to restore backup from user %s", getUser(ctx)))
+    summary, err := persistence.restoreBackup(ctx.Request.Context(), ctx.Request.Body)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to restore backup: %v", err))
+        switch err.(type) {
+        case InvalidBackupError:
+            StandardHTTP.InvalidRequestWithMessage(ctx, err.Error())
+        default:
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": summary})
+}
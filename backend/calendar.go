@@ -0,0 +1,173 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strings"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    ScopeCalendar = "calendar"
+
+    icsEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n")
+)
+
+// define struct used to build iCalendar documents. lines are
+// folded and terminated with CRLF as required by RFC 5545
+type CalendarWriter struct {
+    builder   strings.Builder
+    timestamp time.Time
+}
+
+// function used to write content line to calendar. lines longer
+// than 75 octets are folded onto continuation lines
+func(writer *CalendarWriter) line(format string, args ...interface{}) {
+    content := fmt.Sprintf(format, args...)
+    for len(content) > 75 {
+        // avoid splitting multi-byte characters when folding lines
+        split := 75
+        for split > 0 && content[split] & 0xC0 == 0x80 {
+            split--
+        }
+        writer.builder.WriteString(content[:split] + "\r\n")
+        content = " " + content[split:]
+    }
+    writer.builder.WriteString(content + "\r\n")
+}
+
+// function used to format timestamps in iCalendar UTC format
+func icsTime(t time.Time) string {
+    return t.UTC().Format("20060102T150405Z")
+}
+
+// function used to write event to calendar
+func(writer *CalendarWriter) event(uid string, start, end time.Time, summary, description string) {
+    writer.line("BEGIN:VEVENT")
+    writer.line("UID:%s", uid)
+    writer.line("DTSTAMP:%s", icsTime(writer.timestamp))
+    writer.line("DTSTART:%s", icsTime(start))
+    writer.line("DTEND:%s", icsTime(end))
+    writer.line("SUMMARY:%s", icsEscaper.Replace(summary))
+    if len(description) > 0 {
+        writer.line("DESCRIPTION:%s", icsEscaper.Replace(description))
+    }
+    writer.line("TRANSP:TRANSPARENT")
+    writer.line("END:VEVENT")
+}
+
+// function used to generate iCalendar feed from list of work periods.
+// each closed period is published as an event, and break periods can
+// optionally be published as separate events
+func generateCalendar(ctx context.Context, uid string, periods []models.WorkPeriod, includeBreaks bool) string {
+    // all events share the time at which the feed was generated
+    writer := &CalendarWriter{timestamp: time.Now().UTC()}
+    writer.line("BEGIN:VCALENDAR")
+    writer.line("VERSION:2.0")
+    writer.line("PRODID:-//go-timesheets//timesheet feed//EN")
+    writer.line("CALSCALE:GREGORIAN")
+    writer.line("METHOD:PUBLISH")
+    writer.line("X-WR-CALNAME:%s", icsEscaper.Replace(fmt.Sprintf("Timesheet %s", uid)))
+    writer.line("REFRESH-INTERVAL;VALUE=DURATION:PT15M")
+    writer.line("X-PUBLISHED-TTL:PT15M")
+    for _, period := range(periods) {
+        if period.FinishedAt == nil {
+            continue
+        }
+        breaks := analyseBreaks(ctx, period.Breaks)
+        summary := fmt.Sprintf("Work (%.2fh net)", period.TotalHours() - breaks.TotalHours)
+        description := fmt.Sprintf("Total: %.2fh\nBreaks: %d (%.2fh)", period.TotalHours(), breaks.BreakCount, breaks.TotalHours)
+        writer.event(fmt.Sprintf("%s@go-timesheets", period.PeriodId), period.CreatedAt, *period.FinishedAt, summary, description)
+
+        if includeBreaks {
+            for _, breakPeriod := range(period.Breaks) {
+                if breakPeriod.FinishedAt == nil {
+                    continue
+                }
+                writer.event(fmt.Sprintf("%s@go-timesheets", breakPeriod.BreakId), breakPeriod.CreatedAt, *breakPeriod.FinishedAt, "Break", "")
+            }
+        }
+    }
+    writer.line("END:VCALENDAR")
+    return writer.builder.String()
+}
+
+// function used to create new calendar feed for current user. feeds are
+// backed by API tokens with the calendar scope, meaning that feeds are
+// listed and revoked in the same manner as all other API tokens
+func createCalendarFeedHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    // prevent tokens from being used to create further (calendar) tokens
+    if _, ok := ctx.Get("tokenScopes"); ok {
+        log.Warn(fmt.Sprintf("user %s attempted to create calendar feed using API token", user))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to create calendar feed for user %s", user))
+    token, err := generateAPIToken()
+    if err != nil {
+        log.Error(fmt.Errorf("unable to generate calendar token: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    details, err := persistence.createAPIToken(ctx.Request.Context(), user, "calendar feed", hashAPIToken(token), []string{ScopeCalendar})
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create calendar feed for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    payload := gin.H{
+        "url": fmt.Sprintf("%s/go-timesheets/calendar/%s.ics", PublicURL, token),
+        "details": details,
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
+}
+
+// function used to serve calendar feed. feeds are authenticated using the
+// token in the URL, allowing calendar applications to subscribe to them.
+// the date range defaults to the last 90 days and can be set using the
+// start and end query parameters in YYYY-MM-DD format
+func getCalendarFeedHandler(ctx *gin.Context) {
+    token := strings.TrimSuffix(ctx.Param("token"), ".ics")
+    details, uid, err := persistence.getAPITokenByHash(ctx.Request.Context(), hashAPIToken(token))
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            log.Error(fmt.Errorf("unable to retrieve calendar token: %v", err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    if details.RevokedAt != nil || len(details.Scopes) != 1 || details.Scopes[0] != ScopeCalendar {
+        StandardHTTP.NotFound(ctx)
+        return
+    }
+
+    now := time.Now().UTC()
+    start, end, err := parseTimestamps(ctx.DefaultQuery("start", now.AddDate(0, 0, -90).Format("2006-01-02")),
+        ctx.DefaultQuery("end", now.Format("2006-01-02")), "2006-01-02")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse timestamps: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timestamp(s)")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to get calendar feed for user %s", uid))
+    data, err := persistence.getUserDataOverRange(ctx.Request.Context(), uid, start, end.Add(time.Hour * 24))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve data for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    if err := persistence.touchAPIToken(ctx.Request.Context(), details.TokenId); err != nil {
+        log.Error(fmt.Errorf("unable to update last used timestamp of token %s: %v", details.TokenId, err))
+    }
+    includeBreaks := strings.ToLower(ctx.DefaultQuery("include_breaks", "false")) == "true"
+    ctx.Header("Cache-Control", "no-cache")
+    ctx.Data(200, "text/calendar; charset=utf-8", []byte(generateCalendar(ctx.Request.Context(), uid, data.WorkPeriods, includeBreaks)))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "net/http"
+    "encoding/json"
+)
+
+// define struct used to send requests to the timesheets API. requests
+// are authenticated using a personal API token
+type Client struct {
+    BaseURL string
+    Token   string
+    http    *http.Client
+}
+
+// define error returned when the API responds with an error status
+type APIError struct {
+    StatusCode int
+    Message    string
+}
+
+func(err APIError) Error() string {
+    if len(err.Message) == 0 {
+        return fmt.Sprintf("received invalid response code %d", err.StatusCode)
+    }
+    return fmt.Sprintf("%s (%d)", err.Message, err.StatusCode)
+}
+
+// define struct used to decode standard API responses. note that some
+// routes return their results as data rather than as payload
+type APIResponse struct {
+    Success  bool            `json:"success"`
+    HttpCode int             `json:"http_code"`
+    Message  string          `json:"message"`
+    Payload  json.RawMessage `json:"payload"`
+    Data     json.RawMessage `json:"data"`
+}
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+    return &Client{BaseURL: baseURL, Token: token, http: &http.Client{Timeout: timeout}}
+}
+
+// function used to send request to API and decode the payload of the
+// response into the given target. target may be nil if the response
+// does not contain a payload
+func(client *Client) request(method, path string, body, target interface{}) error {
+    var content bytes.Buffer
+    if body != nil {
+        if err := json.NewEncoder(&content).Encode(body); err != nil {
+            return err
+        }
+    }
+    request, err := http.NewRequest(method, client.BaseURL + "/go-timesheets" + path, &content)
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Authorization", "Bearer " + client.Token)
+    request.Header.Set("Accept", "application/json")
+    if body != nil {
+        request.Header.Set("Content-Type", "application/json")
+    }
+
+    response, err := client.http.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    var result APIResponse
+    if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+        return APIError{StatusCode: response.StatusCode}
+    }
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return APIError{StatusCode: response.StatusCode, Message: result.Message}
+    }
+    if target == nil {
+        return nil
+    }
+    payload := result.Payload
+    if len(payload) == 0 {
+        payload = result.Data
+    }
+    return json.Unmarshal(payload, target)
+}
+
+// function used to determine if error was caused by a missing resource
+func isNotFound(err error) bool {
+    apiErr, ok := err.(APIError)
+    return ok && apiErr.StatusCode == 404
+}
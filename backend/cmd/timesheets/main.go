@@ -0,0 +1,333 @@
+// Command timesheets is a command line client used to clock in and out of
+// work and break periods using the go-timesheets API. requests are
+// authenticated using a personal API token, which can be created using
+// the /go-timesheets/tokens route
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "sort"
+    "strings"
+    "encoding/json"
+    "text/tabwriter"
+    "github.com/PSauerborn/go-timesheets/models"
+)
+
+var (
+    DateLayout = "2006-01-02"
+
+    commands = map[string]Command{
+        "start": {"clock in by starting a new work period", startCommand},
+        "stop": {"clock out by closing the active work period", stopCommand},
+        "break": {"start a break in the active work period", breakCommand},
+        "resume": {"end the active break", resumeCommand},
+        "status": {"show the active work period and break", statusCommand},
+        "log": {"list completed work periods [--from YYYY-MM-DD] [--to YYYY-MM-DD]", logCommand},
+        "report": {"summarise hours worked [--from YYYY-MM-DD] [--to YYYY-MM-DD]", reportCommand},
+    }
+)
+
+// define command executed by the client. commands return a result that is
+// written when using JSON output along with a human readable summary
+type Command struct {
+    Description string
+    Run         func(client *Client, args []string) (interface{}, string, error)
+}
+
+func main() {
+    flags := flag.NewFlagSet("timesheets", flag.ExitOnError)
+    url := flags.String("url", getEnv("TIMESHEETS_URL", "http://localhost:10091"), "base URL of the timesheets API")
+    token := flags.String("token", os.Getenv("TIMESHEETS_TOKEN"), "personal API token used to authenticate requests")
+    output := flags.String("output", getEnv("TIMESHEETS_OUTPUT", "text"), "output format, either text or json")
+    timeout := flags.Duration("timeout", 30 * time.Second, "timeout applied to requests")
+    flags.Usage = func() { usage(flags) }
+    flags.Parse(os.Args[1:])
+
+    command, ok := commands[flags.Arg(0)]
+    if !ok {
+        usage(flags)
+        os.Exit(2)
+    }
+    // options can also be given after the command i.e. status --output json
+    args, err := parseGlobalFlags(flags, flags.Args()[1:])
+    if err != nil {
+        fatal(err)
+    }
+    if *output != "text" && *output != "json" {
+        fatal(fmt.Errorf("received invalid output format %s", *output))
+    }
+    if len(*token) == 0 {
+        fatal(fmt.Errorf("an API token is required, set TIMESHEETS_TOKEN or use --token"))
+    }
+
+    client := NewClient(strings.TrimSuffix(*url, "/"), *token, *timeout)
+    result, summary, err := command.Run(client, args)
+    if err != nil {
+        fatal(err)
+    }
+    if *output == "json" {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(result)
+    } else {
+        fmt.Println(summary)
+    }
+}
+
+// function used to print usage of client along with all commands
+func usage(flags *flag.FlagSet) {
+    fmt.Fprintf(flags.Output(), "usage: timesheets [options] <command> [arguments]\n\ncommands:\n")
+    names := []string{}
+    for name := range(commands) {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range(names) {
+        fmt.Fprintf(flags.Output(), "  %-8s %s\n", name, commands[name].Description)
+    }
+    fmt.Fprintf(flags.Output(), "\noptions:\n")
+    flags.PrintDefaults()
+}
+
+// function used to parse options given after the command. options are
+// removed from the arguments, while all other arguments are returned in
+// order so that they can be parsed by the command itself
+func parseGlobalFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+    remaining := []string{}
+    for i := 0; i < len(args); i++ {
+        if args[i] == "--" {
+            return append(remaining, args[i + 1:]...), nil
+        }
+        name := strings.TrimLeft(args[i], "-")
+        option := flags.Lookup(strings.SplitN(name, "=", 2)[0])
+        if name == args[i] || option == nil {
+            remaining = append(remaining, args[i])
+            continue
+        }
+        value := ""
+        if index := strings.Index(name, "="); index >= 0 {
+            name, value = name[:index], name[index + 1:]
+        } else if boolean, ok := option.Value.(interface{ IsBoolFlag() bool }); ok && boolean.IsBoolFlag() {
+            value = "true"
+        } else if i + 1 < len(args) {
+            value = args[i + 1]
+            i++
+        } else {
+            return nil, fmt.Errorf("flag needs an argument: -%s", name)
+        }
+        if err := flags.Set(name, value); err != nil {
+            return nil, fmt.Errorf("invalid value %q for flag -%s: %v", value, name, err)
+        }
+    }
+    return remaining, nil
+}
+
+// function used to print error and exit
+func fatal(err error) {
+    fmt.Fprintf(os.Stderr, "error: %v\n", err)
+    os.Exit(1)
+}
+
+// function used to read environment variable with default value
+func getEnv(key, defaultValue string) string {
+    if value := os.Getenv(key); len(value) > 0 {
+        return value
+    }
+    return defaultValue
+}
+
+// function used to retrieve the active work period. a nil period is
+// returned if the user is not clocked in
+func getActivePeriod(client *Client) (*models.ActiveWorkPeriod, error) {
+    var period models.ActiveWorkPeriod
+    if err := client.request("GET", "/active", nil, &period); err != nil {
+        if isNotFound(err) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &period, nil
+}
+
+// function used to retrieve the active work period, returning an error
+// if the user is not clocked in
+func requireActivePeriod(client *Client) (*models.ActiveWorkPeriod, error) {
+    period, err := getActivePeriod(client)
+    if err == nil && period == nil {
+        err = fmt.Errorf("not clocked in")
+    }
+    return period, err
+}
+
+func startCommand(client *Client, args []string) (interface{}, string, error) {
+    var period models.ActiveWorkPeriod
+    if err := client.request("POST", "/work_period", nil, &period); err != nil {
+        return nil, "", err
+    }
+    return period, fmt.Sprintf("clocked in at %s", formatTime(period.CreatedAt)), nil
+}
+
+func stopCommand(client *Client, args []string) (interface{}, string, error) {
+    period, err := requireActivePeriod(client)
+    if err != nil {
+        return nil, "", err
+    }
+    if err := client.request("PATCH", fmt.Sprintf("/work_period/%s", period.PeriodId), nil, nil); err != nil {
+        return nil, "", err
+    }
+    finished := time.Now()
+    result := map[string]interface{}{"periodId": period.PeriodId, "createdAt": period.CreatedAt, "finishedAt": finished}
+    return result, fmt.Sprintf("clocked out at %s after %s", formatTime(finished), formatDuration(finished.Sub(period.CreatedAt))), nil
+}
+
+func breakCommand(client *Client, args []string) (interface{}, string, error) {
+    period, err := requireActivePeriod(client)
+    if err != nil {
+        return nil, "", err
+    }
+    if period.ActiveBreak != nil {
+        return nil, "", fmt.Errorf("already on break since %s", formatTime(period.ActiveBreak.CreatedAt))
+    }
+    var activeBreak models.ActiveBreakPeriod
+    if err := client.request("POST", fmt.Sprintf("/break_period/%s", period.PeriodId), nil, &activeBreak); err != nil {
+        return nil, "", err
+    }
+    return activeBreak, fmt.Sprintf("started break at %s", formatTime(activeBreak.CreatedAt)), nil
+}
+
+func resumeCommand(client *Client, args []string) (interface{}, string, error) {
+    period, err := requireActivePeriod(client)
+    if err != nil {
+        return nil, "", err
+    }
+    if period.ActiveBreak == nil {
+        return nil, "", fmt.Errorf("not on break")
+    }
+    if err := client.request("PATCH", fmt.Sprintf("/break_period/%s", period.ActiveBreak.BreakId), nil, nil); err != nil {
+        return nil, "", err
+    }
+    finished := time.Now()
+    result := map[string]interface{}{"breakId": period.ActiveBreak.BreakId, "createdAt": period.ActiveBreak.CreatedAt, "finishedAt": finished}
+    return result, fmt.Sprintf("resumed work at %s after %s break", formatTime(finished), formatDuration(finished.Sub(period.ActiveBreak.CreatedAt))), nil
+}
+
+func statusCommand(client *Client, args []string) (interface{}, string, error) {
+    period, err := getActivePeriod(client)
+    if err != nil {
+        return nil, "", err
+    }
+    if period == nil {
+        return map[string]interface{}{"active": false}, "not clocked in", nil
+    }
+    summary := fmt.Sprintf("clocked in since %s (%s)", formatTime(period.CreatedAt), formatDuration(time.Since(period.CreatedAt)))
+    if period.ActiveBreak != nil {
+        summary += fmt.Sprintf("\non break since %s (%s)", formatTime(period.ActiveBreak.CreatedAt), formatDuration(time.Since(period.ActiveBreak.CreatedAt)))
+    }
+    return map[string]interface{}{"active": true, "period": period}, summary, nil
+}
+
+func logCommand(client *Client, args []string) (interface{}, string, error) {
+    today := truncateDay(time.Now())
+    start, end, err := parseRange("log", args, today.AddDate(0, 0, -6), today)
+    if err != nil {
+        return nil, "", err
+    }
+    periods := []models.WorkPeriod{}
+    if err := client.request("GET", fmt.Sprintf("/data/%s/%s", start.Format(DateLayout), end.Format(DateLayout)), nil, &periods); err != nil {
+        return nil, "", err
+    }
+    sort.Slice(periods, func(i, j int) bool { return periods[i].CreatedAt.Before(periods[j].CreatedAt) })
+
+    var summary strings.Builder
+    writer := tabwriter.NewWriter(&summary, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(writer, "DATE\tSTART\tEND\tBREAKS\tNET")
+    // note that only completed periods are returned, the active period
+    // is shown using the status command
+    for _, period := range(periods) {
+        total, breaks := period.FinishedAt.Sub(period.CreatedAt), breakDuration(period.Breaks)
+        fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", period.CreatedAt.Local().Format(DateLayout), formatTime(period.CreatedAt),
+            formatTime(*period.FinishedAt), formatDuration(breaks), formatDuration(total - breaks))
+    }
+    writer.Flush()
+    return periods, strings.TrimSuffix(summary.String(), "\n"), nil
+}
+
+func reportCommand(client *Client, args []string) (interface{}, string, error) {
+    today := truncateDay(time.Now())
+    weekday := (int(today.Weekday()) + 6) % 7
+    start, end, err := parseRange("report", args, today.AddDate(0, 0, -weekday), today)
+    if err != nil {
+        return nil, "", err
+    }
+    // the end of the analysed range is exclusive, meaning that the day
+    // following the end date is used to include the end date itself
+    var results models.AnalysisResults
+    path := fmt.Sprintf("/analyse/%s/%s", start.Format(DateLayout), end.AddDate(0, 0, 1).Format(DateLayout))
+    if err := client.request("GET", path, nil, &results); err != nil {
+        return nil, "", err
+    }
+    report := map[string]interface{}{"start": start.Format(DateLayout), "end": end.Format(DateLayout), "results": results}
+
+    var summary strings.Builder
+    writer := tabwriter.NewWriter(&summary, 0, 0, 2, ' ', 0)
+    fmt.Fprintf(writer, "period\t%s to %s\n", start.Format(DateLayout), end.Format(DateLayout))
+    fmt.Fprintf(writer, "work periods\t%d\n", results.TotalPeriods)
+    fmt.Fprintf(writer, "breaks\t%d\n", results.TotalBreaks)
+    fmt.Fprintf(writer, "work hours\t%.2f\n", results.TotalWorkHours)
+    fmt.Fprintf(writer, "break hours\t%.2f\n", results.TotalBreakHours)
+    fmt.Fprintf(writer, "net hours\t%.2f\n", results.NetWorkHours)
+    writer.Flush()
+    return report, strings.TrimSuffix(summary.String(), "\n"), nil
+}
+
+// function used to parse date range given as --from and --to arguments
+func parseRange(name string, args []string, defaultStart, defaultEnd time.Time) (time.Time, time.Time, error) {
+    flags := flag.NewFlagSet(name, flag.ContinueOnError)
+    from := flags.String("from", defaultStart.Format(DateLayout), "first day of range")
+    to := flags.String("to", defaultEnd.Format(DateLayout), "last day of range")
+    if err := flags.Parse(args); err != nil {
+        return time.Time{}, time.Time{}, err
+    }
+    start, err := time.Parse(DateLayout, *from)
+    if err != nil {
+        return time.Time{}, time.Time{}, fmt.Errorf("invalid start date %s", *from)
+    }
+    end, err := time.Parse(DateLayout, *to)
+    if err != nil {
+        return time.Time{}, time.Time{}, fmt.Errorf("invalid end date %s", *to)
+    }
+    if end.Before(start) {
+        return time.Time{}, time.Time{}, fmt.Errorf("end date must not be before start date")
+    }
+    return start, end, nil
+}
+
+// function used to evaluate total duration of breaks. active breaks are
+// counted up until the current time
+func breakDuration(breaks []models.BreakPeriod) time.Duration {
+    var total time.Duration
+    for _, period := range(breaks) {
+        if period.FinishedAt != nil {
+            total += period.FinishedAt.Sub(period.CreatedAt)
+        } else {
+            total += time.Since(period.CreatedAt)
+        }
+    }
+    return total
+}
+
+func truncateDay(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func formatTime(t time.Time) string {
+    return t.Local().Format("15:04")
+}
+
+func formatDuration(duration time.Duration) string {
+    minutes := int(duration.Round(time.Minute).Minutes())
+    return fmt.Sprintf("%dh%02dm", minutes / 60, minutes % 60)
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "time"
+    "context"
+    "strings"
+    "syscall"
+    "os/signal"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    CommandActor = "admin-cli"
+
+    // define map of commands supported by the service binary. the API
+    // server is started if no command is given
+    serviceCommands = map[string]ServiceCommand{
+        "serve": {"start the API server", serveCommand},
+        "migrate": {"create or update the postgres schema", migrateCommand},
+        "close-stale": {"close work periods that have been active for too long", closeStaleCommand},
+        "export": {"write instance backup in JSON lines format", exportCommand},
+        "import": {"restore instance from JSON lines backup", importCommand},
+        "recompute": {"recompute break periods from the work periods they belong to", recomputeCommand},
+        "user delete": {"delete or anonymise all data stored for a user", deleteUserCommand},
+    }
+)
+
+// define command executed by the service binary. commands are given the
+// remaining arguments, which contain both command flags and configuration
+// flags i.e. --postgres-connection
+type ServiceCommand struct {
+    Description string
+    Run         func(args []string) error
+}
+
+func main() {
+    name, args := resolveCommand(os.Args[1:])
+    command, ok := serviceCommands[name]
+    if !ok {
+        printCommandUsage()
+        os.Exit(2)
+    }
+    if err := command.Run(args); err != nil {
+        log.Fatal(err)
+    }
+}
+
+// function used to resolve command from arguments. commands are given as
+// one or more words before any flags, i.e. 'user delete --uid <uid>'
+func resolveCommand(args []string) (string, []string) {
+    words := 0
+    for words < len(args) && !strings.HasPrefix(args[words], "-") {
+        words++
+    }
+    if words == 0 {
+        return "serve", args
+    }
+    return strings.Join(args[:words], " "), args[words:]
+}
+
+// function used to print all commands supported by the service binary
+func printCommandUsage() {
+    names := []string{}
+    for name := range(serviceCommands) {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    fmt.Fprintf(os.Stderr, "usage: go-timesheets [command] [flags]\n\ncommands:\n")
+    for _, name := range(names) {
+        fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, serviceCommands[name].Description)
+    }
+    fmt.Fprintf(os.Stderr, "\nconfiguration variables can be given as flags, i.e. --postgres-connection\n")
+}
+
+// function used to set up maintenance commands. command flags are parsed
+// using the given flag set, and all remaining arguments are used to
+// configure the service before connecting to postgres. the returned
+// context is cancelled if a termination signal is received
+func setupCommand(flags *flag.FlagSet, args []string) (context.Context, context.CancelFunc, error) {
+    commandArgs, serviceArgs := splitCommandArgs(flags, args)
+    if err := flags.Parse(commandArgs); err != nil {
+        return nil, nil, err
+    }
+    ConfigureService(serviceArgs)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        select {
+        case sig := <-signals:
+            log.Warn(fmt.Sprintf("received signal %s, cancelling command", sig))
+            cancel()
+        case <-ctx.Done():
+        }
+        signal.Stop(signals)
+    }()
+    if err := ConnectPersistence(ctx); err != nil {
+        cancel()
+        return nil, nil, err
+    }
+    return ctx, cancel, nil
+}
+
+// function used to split arguments into flags defined by the command and
+// flags used to configure the service
+func splitCommandArgs(flags *flag.FlagSet, args []string) ([]string, []string) {
+    commandArgs, serviceArgs := []string{}, []string{}
+    for i := 0; i < len(args); i++ {
+        name := strings.TrimLeft(args[i], "-")
+        if index := strings.Index(name, "="); index >= 0 {
+            name = name[:index]
+        }
+        // values given as separate arguments belong to the preceding flag
+        takesValue := !strings.Contains(args[i], "=") && i + 1 < len(args) && !strings.HasPrefix(args[i + 1], "--")
+        definition := flags.Lookup(name)
+        if definition != nil {
+            if boolean, ok := definition.Value.(interface{ IsBoolFlag() bool }); ok && boolean.IsBoolFlag() {
+                takesValue = false
+            }
+        }
+        target := &serviceArgs
+        if definition != nil {
+            target = &commandArgs
+        }
+        *target = append(*target, args[i])
+        if takesValue {
+            *target = append(*target, args[i + 1])
+            i++
+        }
+    }
+    return commandArgs, serviceArgs
+}
+
+// function used to create or update the postgres schema. the schema is
+// initialized regardless of the INITIALIZE_SCHEMA setting
+func migrateCommand(args []string) error {
+    flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+    commandArgs, serviceArgs := splitCommandArgs(flags, args)
+    if err := flags.Parse(commandArgs); err != nil {
+        return err
+    }
+    ConfigureService(serviceArgs)
+    InitializeSchema = true
+    if err := ConnectPersistence(context.Background()); err != nil {
+        return err
+    }
+    defer ClosePersistence()
+    log.Info("postgres schema is up to date")
+    return nil
+}
+
+// function used to close work periods that have been active for longer
+// than the given number of hours. periods are closed at the maximum
+// duration rather than the current time, and active breaks are closed
+// along with the period. periods in approved weeks are skipped
+func closeStaleCommand(args []string) error {
+    flags := flag.NewFlagSet("close-stale", flag.ExitOnError)
+    maxHours := flags.Int("max-hours", 24, "maximum duration of work periods in hours")
+    dryRun := flags.Bool("dry-run", false, "list stale work periods without closing them")
+    ctx, cancel, err := setupCommand(flags, args)
+    if err != nil {
+        return err
+    }
+    defer cancel()
+    defer ClosePersistence()
+    if *maxHours < 1 {
+        return fmt.Errorf("received invalid maximum duration %d", *maxHours)
+    }
+
+    maxDuration := time.Duration(*maxHours) * time.Hour
+    periods, err := persistence.getStalePeriods(ctx, time.Now().Add(-maxDuration))
+    if err != nil {
+        return err
+    }
+    closed := 0
+    for _, period := range(periods) {
+        finishedAt := period.CreatedAt.Add(maxDuration)
+        if *dryRun {
+            log.Info(fmt.Sprintf("would close work period %s for user %s at %s", period.PeriodId, period.Uid, finishedAt.Format(time.RFC3339)))
+            continue
+        }
+        if err := persistence.closeWorkPeriodAt(ctx, period.PeriodId, finishedAt); err != nil {
+            switch err {
+            case ErrPeriodClosed:
+                continue
+            case ErrWeekLocked:
+                log.Warn(fmt.Sprintf("skipping work period %s for user %s in approved week", period.PeriodId, period.Uid))
+                continue
+            }
+            return fmt.Errorf("unable to close work period %s: %v", period.PeriodId, err)
+        }
+        log.Info(fmt.Sprintf("closed work period %s for user %s at %s", period.PeriodId, period.Uid, finishedAt.Format(time.RFC3339)))
+        closed++
+    }
+    log.Info(fmt.Sprintf("found %d stale work periods, closed %d", len(periods), closed))
+    return nil
+}
+
+// function used to write instance backup to a file or standard output
+func exportCommand(args []string) error {
+    flags := flag.NewFlagSet("export", flag.ExitOnError)
+    path := flags.String("file", "-", "file to write backup to, or - for standard output")
+    ctx, cancel, err := setupCommand(flags, args)
+    if err != nil {
+        return err
+    }
+    defer cancel()
+    defer ClosePersistence()
+
+    var writer io.Writer = os.Stdout
+    if *path != "-" {
+        file, err := os.Create(*path)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        writer = file
+    }
+    summary, err := persistence.writeBackup(ctx, writer)
+    if err != nil {
+        return fmt.Errorf("unable to write backup: %v", err)
+    }
+    log.Info(fmt.Sprintf("successfully exported %d work periods and %d break periods", summary.WorkPeriods, summary.BreakPeriods))
+    return nil
+}
+
+// function used to restore instance from a file or standard input
+func importCommand(args []string) error {
+    flags := flag.NewFlagSet("import", flag.ExitOnError)
+    path := flags.String("file", "-", "file to read backup from, or - for standard input")
+    ctx, cancel, err := setupCommand(flags, args)
+    if err != nil {
+        return err
+    }
+    defer cancel()
+    defer ClosePersistence()
+
+    var reader io.Reader = os.Stdin
+    if *path != "-" {
+        file, err := os.Open(*path)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        reader = file
+    }
+    if _, err := persistence.restoreBackup(ctx, reader); err != nil {
+        return fmt.Errorf("unable to restore backup: %v", err)
+    }
+    return nil
+}
+
+// function used to recompute break periods of finished work periods.
+// breaks are clamped to the work period they belong to, and breaks that
+// are still active are closed at the end of the work period. breaks in
+// approved weeks are skipped unless forced
+func recomputeCommand(args []string) error {
+    flags := flag.NewFlagSet("recompute", flag.ExitOnError)
+    dryRun := flags.Bool("dry-run", false, "report changes without applying them")
+    force := flags.Bool("force", false, "also update break periods in approved weeks")
+    ctx, cancel, err := setupCommand(flags, args)
+    if err != nil {
+        return err
+    }
+    defer cancel()
+    defer ClosePersistence()
+
+    updated, skipped, err := persistence.recomputeBreakPeriods(ctx, *dryRun, *force)
+    if err != nil {
+        return fmt.Errorf("unable to recompute break periods: %v", err)
+    }
+    if *dryRun {
+        log.Info(fmt.Sprintf("would update %d break periods", updated))
+    } else {
+        log.Info(fmt.Sprintf("successfully updated %d break periods", updated))
+    }
+    if skipped > 0 {
+        log.Warn(fmt.Sprintf("skipped %d break periods in approved weeks, use --force to update them", skipped))
+    }
+    return nil
+}
+
+// function used to delete or anonymise all data stored for a user. the
+// erasure is recorded in the audit log using the given actor
+func deleteUserCommand(args []string) error {
+    flags := flag.NewFlagSet("user delete", flag.ExitOnError)
+    uid := flags.String("uid", "", "ID of the user to erase")
+    mode := flags.String("mode", ErasureDelete, fmt.Sprintf("erasure mode, either %s or %s", ErasureDelete, ErasureAnonymise))
+    actor := flags.String("actor", CommandActor, "actor recorded in the audit log")
+    ctx, cancel, err := setupCommand(flags, args)
+    if err != nil {
+        return err
+    }
+    defer cancel()
+    defer ClosePersistence()
+    if len(*uid) == 0 {
+        return fmt.Errorf("user delete requires --uid to be set")
+    }
+    if *mode != ErasureDelete && *mode != ErasureAnonymise {
+        return fmt.Errorf("received invalid erasure mode %s", *mode)
+    }
+
+    summary, err := persistence.eraseUserData(ctx, *uid, *actor, *mode)
+    if err != nil {
+        return fmt.Errorf("unable to erase data for user %s: %v", *uid, err)
+    }
+    log.Info(fmt.Sprintf("successfully erased data for user %s with mode %s: %d work periods, %d break periods, %d timesheets",
+        summary.Uid, summary.Mode, summary.WorkPeriods, summary.BreakPeriods, summary.Timesheets))
+    return nil
+}
+
+// ###########################################################
+// # Define persistence functions used by maintenance commands
+// ###########################################################
+
+// define struct used to store work periods that are still active
+type StalePeriod struct {
+    PeriodId  uuid.UUID
+    Uid       string
+    CreatedAt time.Time
+}
+
+// function used to retrieve active work periods started before cutoff
+func(db Persistence) getStalePeriods(ctx context.Context, cutoff time.Time) ([]StalePeriod, error) {
+    periods := []StalePeriod{}
+    rows, err := db.conn.Query(ctx, `SELECT period_id,uid,created_at FROM work_periods
+        WHERE finished_at IS NULL AND created_at < $1 ORDER BY created_at`, cutoff)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve stale work periods: %v", err))
+        return periods, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var period StalePeriod
+        if err := rows.Scan(&period.PeriodId, &period.Uid, &period.CreatedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process work period: %v", err))
+            return periods, err
+        }
+        periods = append(periods, period)
+    }
+    return periods, rows.Err()
+}
+
+// function used to clamp break periods to the finished work periods they
+// belong to. breaks in approved weeks are skipped unless forced, and an
+// event is recorded for each updated break. changes are rolled back if dry
+// run is set, meaning that the number of affected break periods can be
+// inspected before applying them
+func(db Persistence) recomputeBreakPeriods(ctx context.Context, dryRun, force bool) (int, int, error) {
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return 0, 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    // work periods are locked before weeks are checked, matching the order
+    // used when periods are modified through the API
+    rows, err := tx.Query(ctx, `SELECT b.break_id,b.period_id,w.uid,w.created_at,w.finished_at,b.created_at,b.finished_at
+        FROM break_periods b JOIN work_periods w ON b.period_id=w.period_id WHERE w.finished_at IS NOT NULL
+        AND (b.finished_at IS NULL OR b.created_at < w.created_at OR b.created_at > w.finished_at OR b.finished_at > w.finished_at)
+        ORDER BY w.uid,b.created_at FOR UPDATE OF w,b`)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break periods: %v", err))
+        return 0, 0, err
+    }
+    type candidate struct {
+        uid     string
+        period  models.WorkPeriod
+        current models.BreakPeriod
+    }
+    candidates := []candidate{}
+    for rows.Next() {
+        var item candidate
+        err := rows.Scan(&item.current.BreakId, &item.period.PeriodId, &item.uid, &item.period.CreatedAt, &item.period.FinishedAt,
+            &item.current.CreatedAt, &item.current.FinishedAt)
+        if err != nil {
+            rows.Close()
+            logger(ctx).Error(fmt.Errorf("unable to process break period: %v", err))
+            return 0, 0, err
+        }
+        candidates = append(candidates, item)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, 0, err
+    }
+
+    updated, skipped := 0, 0
+    for _, item := range(candidates) {
+        if !force {
+            switch err := lockTimesheetWeek(ctx, tx, item.uid, weekStart(item.period.CreatedAt)); err {
+            case nil:
+            case ErrWeekLocked:
+                skipped++
+                continue
+            default:
+                return updated, skipped, err
+            }
+        }
+        // clamp start and end of break to work period. active breaks are
+        // closed at the end of the work period
+        start, end := item.current.CreatedAt, *item.period.FinishedAt
+        if start.Before(item.period.CreatedAt) {
+            start = item.period.CreatedAt
+        }
+        if start.After(end) {
+            start = end
+        }
+        if item.current.FinishedAt != nil && item.current.FinishedAt.Before(end) {
+            end = *item.current.FinishedAt
+        }
+        if end.Before(start) {
+            end = start
+        }
+        recomputed := models.BreakPeriod{BreakId: item.current.BreakId, CreatedAt: start, FinishedAt: &end}
+        if _, err := tx.Exec(ctx, "UPDATE break_periods SET created_at=$1, finished_at=$2 WHERE break_id=$3", start, end, recomputed.BreakId); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to update break period %s: %v", recomputed.BreakId, err))
+            return updated, skipped, err
+        }
+        event := EventBreakPeriodUpdated
+        if item.current.FinishedAt == nil {
+            event = EventBreakPeriodClosed
+        }
+        if _, err := recordPeriodEvent(ctx, tx, item.uid, event, item.period.PeriodId, &recomputed.BreakId, recomputed); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to record break period event: %v", err))
+            return updated, skipped, err
+        }
+        updated++
+    }
+    if dryRun {
+        return updated, skipped, nil
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit break periods: %v", err))
+        return 0, 0, err
+    }
+    return updated, skipped, nil
+}
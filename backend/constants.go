@@ -55,10 +55,18 @@ func(response StandardJSONResponse) NotFound(ctx *gin.Context) {
     ctx.AbortWithStatusJSON(404, gin.H{ "http_code": 404, "success": false, "message": "not found" })
 }
 
+func(response StandardJSONResponse) ConflictWithMessage(ctx *gin.Context, msg string) {
+    ctx.AbortWithStatusJSON(409, gin.H{ "http_code": 409, "success": false, "message": msg })
+}
+
 func(response StandardJSONResponse) InternalServerError(ctx *gin.Context) {
     ctx.AbortWithStatusJSON(500, gin.H{ "http_code": 500, "success": false, "message": "internal server error" })
 }
 
 func(response StandardJSONResponse) FeatureNotSupported(ctx *gin.Context) {
     ctx.AbortWithStatusJSON(503, gin.H{ "http_code": 503, "success": false, "message": "feature not yet supported" })
-}
\ No newline at end of file
+}
+
+func(response StandardJSONResponse) ServiceUnavailable(ctx *gin.Context) {
+    ctx.AbortWithStatusJSON(503, gin.H{ "http_code": 503, "success": false, "message": "service not ready" })
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strconv"
+    "encoding/json"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    DefaultEventPageSize = 100
+    MaxEventPageSize = 1000
+)
+
+// function used to append event to the event log using the given
+// transaction. webhook deliveries and active period notifications are
+// written in the same transaction, meaning that they are only published
+// once the change itself has been committed. events for a user are
+// serialized using an advisory lock so that event IDs are committed in
+// order, allowing consumers to use the latest event ID as a cursor
+func recordPeriodEvent(ctx context.Context, tx pgx.Tx, uid, event string, periodId uuid.UUID, breakId *uuid.UUID, data interface{}) (models.PeriodEvent, error) {
+    body, err := json.Marshal(data)
+    if err != nil {
+        return models.PeriodEvent{}, err
+    }
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        return models.PeriodEvent{}, err
+    }
+    record := models.PeriodEvent{Event: event, Uid: uid, PeriodId: periodId, BreakId: breakId, OccurredAt: time.Now().UTC(), Data: body}
+    result := tx.QueryRow(ctx, `INSERT INTO period_events(uid, event, period_id, break_id, data, created_at)
+        VALUES($1,$2,$3,$4,$5,$6) RETURNING event_id`, uid, event, periodId, breakId, body, record.OccurredAt)
+    if err := result.Scan(&record.EventId); err != nil {
+        return models.PeriodEvent{}, err
+    }
+
+    if WebhooksEnabled {
+        payload, err := json.Marshal(record)
+        if err != nil {
+            return models.PeriodEvent{}, err
+        }
+        if err := enqueueWebhookDeliveries(ctx, tx, uid, event, payload); err != nil {
+            return models.PeriodEvent{}, err
+        }
+    }
+    if _, err := tx.Exec(ctx, "SELECT pg_notify($1, $2)", ActivePeriodChannel, uid); err != nil {
+        return models.PeriodEvent{}, err
+    }
+    return record, nil
+}
+
+// function used to record events for a work period that was written outside
+// of the regular clock in and clock out flow, such as imported or restored
+// periods. the same sequence of events is recorded as for live periods,
+// meaning that consumers syncing from the event cursor see the new periods
+func recordWrittenWorkPeriodEvents(ctx context.Context, tx pgx.Tx, uid string, period models.WorkPeriod) error {
+    created := models.WorkPeriod{PeriodId: period.PeriodId, CreatedAt: period.CreatedAt, Breaks: []models.BreakPeriod{}}
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventWorkPeriodCreated, period.PeriodId, nil, created); err != nil {
+        return err
+    }
+    for _, breakPeriod := range(period.Breaks) {
+        if err := recordWrittenBreakPeriodEvents(ctx, tx, uid, period.PeriodId, breakPeriod); err != nil {
+            return err
+        }
+    }
+    if period.FinishedAt != nil {
+        if _, err := recordPeriodEvent(ctx, tx, uid, EventWorkPeriodClosed, period.PeriodId, nil, period); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to record events for a break period that was written
+// outside of the regular flow. see recordWrittenWorkPeriodEvents()
+func recordWrittenBreakPeriodEvents(ctx context.Context, tx pgx.Tx, uid string, periodId uuid.UUID, breakPeriod models.BreakPeriod) error {
+    breakId := breakPeriod.BreakId
+    created := models.BreakPeriod{BreakId: breakId, CreatedAt: breakPeriod.CreatedAt}
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventBreakPeriodCreated, periodId, &breakId, created); err != nil {
+        return err
+    }
+    if breakPeriod.FinishedAt != nil {
+        if _, err := recordPeriodEvent(ctx, tx, uid, EventBreakPeriodClosed, periodId, &breakId, breakPeriod); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to retrieve page of events for current user. events are
+// returned in order, starting after the event ID given in the after query
+// parameter. the cursor returned with each page is used as the after
+// parameter of the next request
+func listPeriodEventsHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    after, err := strconv.ParseInt(ctx.DefaultQuery("after", "0"), 10, 64)
+    if err != nil || after < 0 {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid cursor")
+        return
+    }
+    limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(DefaultEventPageSize)))
+    if err != nil || limit < 1 || limit > MaxEventPageSize {
+        StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("limit must be between 1 and %d", MaxEventPageSize))
+        return
+    }
+
+    log.Debug(fmt.Sprintf("received request to list events after %d for user %s", after, user))
+    events, err := persistence.getPeriodEvents(ctx.Request.Context(), user, after, limit + 1)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve events for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    page := models.PeriodEventPage{Events: events, Cursor: after}
+    if len(events) > limit {
+        page.Events, page.HasMore = events[:limit], true
+    }
+    if len(page.Events) > 0 {
+        page.Cursor = page.Events[len(page.Events) - 1].EventId
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": page})
+}
+
+// ###########################################################
+// # Define persistence functions used to read the event log
+// ###########################################################
+
+// function used to retrieve events for a user after a given event ID
+func(db Persistence) getPeriodEvents(ctx context.Context, uid string, after int64, limit int) ([]models.PeriodEvent, error) {
+    events := []models.PeriodEvent{}
+    rows, err := db.conn.Query(ctx, `SELECT event_id,uid,event,period_id,break_id,data,created_at FROM period_events
+        WHERE uid=$1 AND event_id>$2 ORDER BY event_id LIMIT $3`, uid, after, limit)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve events for user %s: %v", uid, err))
+        return events, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var (event models.PeriodEvent; data []byte)
+        if err := rows.Scan(&event.EventId, &event.Uid, &event.Event, &event.PeriodId, &event.BreakId, &data, &event.OccurredAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process event: %v", err))
+            return events, err
+        }
+        event.Data = json.RawMessage(data)
+        events = append(events, event)
+    }
+    return events, rows.Err()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "strconv"
+    "context"
+    "strings"
+    "encoding/csv"
+    "unicode/utf8"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define named time formats that can be used in exports
+    exportTimeFormats = map[string]string{
+        "datetime": "2006-01-02 15:04:05",
+        "rfc3339": time.RFC3339,
+        "iso8601": "2006-01-02T15:04:05",
+    }
+
+    // work periods are not associated with projects or tags, so exports
+    // do not contain project or tag columns
+    csvExportHeader = []string{"type", "period_id", "break_id", "start", "end", "duration_hours", "break_count", "break_hours", "net_hours"}
+)
+
+type CSVExportOptions struct {
+    Delimiter     rune
+    TimeFormat    string
+    Location      *time.Location
+    IncludeBreaks bool
+}
+
+// function used to parse CSV export options from query parameters. the
+// delimiter can be any single character (or tab) that is accepted by the
+// CSV writer i.e. not a quote, line break or invalid rune, the time format can
+// either be a named format or a go time layout and the timezone must be
+// a valid IANA timezone
+func parseCSVExportOptions(ctx *gin.Context) (CSVExportOptions, error) {
+    options := CSVExportOptions{
+        IncludeBreaks: strings.ToLower(ctx.DefaultQuery("include_breaks", "false")) == "true",
+    }
+    // parse delimiter from query parameters
+    delimiter := ctx.DefaultQuery("delimiter", ",")
+    if delimiter == "tab" {
+        delimiter = "\t"
+    }
+    if utf8.RuneCountInString(delimiter) != 1 {
+        return options, fmt.Errorf("invalid delimiter '%s'", delimiter)
+    }
+    options.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+    if !isValidCSVDelimiter(options.Delimiter) {
+        return options, fmt.Errorf("invalid delimiter '%s'", delimiter)
+    }
+    // parse time format from query parameters
+    options.TimeFormat = ctx.DefaultQuery("time_format", "datetime")
+    if layout, ok := exportTimeFormats[options.TimeFormat]; ok {
+        options.TimeFormat = layout
+    }
+    // parse timezone from query parameters
+    location, err := time.LoadLocation(ctx.DefaultQuery("timezone", "UTC"))
+    if err != nil {
+        return options, fmt.Errorf("invalid timezone: %v", err)
+    }
+    options.Location = location
+    return options, nil
+}
+
+// function used to check if delimiter can be used by CSV writer. this
+// mirrors the rules applied by encoding/csv, which otherwise fails on
+// the first write after the response has already been started
+func isValidCSVDelimiter(delimiter rune) bool {
+    return delimiter != 0 && delimiter != '"' && delimiter != '\r' && delimiter != '\n' &&
+        utf8.ValidRune(delimiter) && delimiter != utf8.RuneError
+}
+
+// function used to format hours in export
+func formatHours(hours float64) string {
+    return strconv.FormatFloat(hours, 'f', 2, 64)
+}
+
+// function used to convert work period into CSV row
+func workPeriodRecord(ctx context.Context, period models.WorkPeriod, options CSVExportOptions) []string {
+    breaks := analyseBreaks(ctx, period.Breaks)
+    return []string{
+        "work_period",
+        period.PeriodId.String(),
+        "",
+        period.CreatedAt.In(options.Location).Format(options.TimeFormat),
+        period.FinishedAt.In(options.Location).Format(options.TimeFormat),
+        formatHours(period.TotalHours()),
+        strconv.Itoa(breaks.BreakCount),
+        formatHours(breaks.TotalHours),
+        formatHours(period.TotalHours() - breaks.TotalHours),
+    }
+}
+
+// function used to convert break period into CSV row
+func breakPeriodRecord(period models.WorkPeriod, breakPeriod models.BreakPeriod, options CSVExportOptions) []string {
+    end, duration := "", ""
+    if breakPeriod.FinishedAt != nil {
+        end = breakPeriod.FinishedAt.In(options.Location).Format(options.TimeFormat)
+        duration = formatHours(breakPeriod.TotalHours())
+    }
+    return []string{
+        "break_period",
+        period.PeriodId.String(),
+        breakPeriod.BreakId.String(),
+        breakPeriod.CreatedAt.In(options.Location).Format(options.TimeFormat),
+        end,
+        duration,
+        "",
+        "",
+        "",
+    }
+}
+
+// function used to export user data over a given time range. data is
+// streamed to the client with one row per work period, and optionally
+// one row per break period following the work period it belongs to
+func exportUserDataHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    // get start and end time from url and parse into time.Time objects
+    start, end, err := parseTimestamps(ctx.Param("start"), ctx.Param("end"), "2006-01-02")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse timestamps: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timestamp(s)")
+        return
+    }
+    format := strings.ToLower(ctx.DefaultQuery("format", "csv"))
+    if format != "csv" {
+        StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("unsupported export format %s", format))
+        return
+    }
+    options, err := parseCSVExportOptions(ctx)
+    if err != nil {
+        log.Error(fmt.Errorf("received invalid export options: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, err.Error())
+        return
+    }
+
+    log.Debug(fmt.Sprintf("received request to export data for user %s", user))
+    data, err := persistence.getUserDataOverRange(ctx.Request.Context(), user, start, end.Add(time.Hour * 24))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve data for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+
+    filename := fmt.Sprintf("timesheet_%s_%s.csv", start.Format("2006-01-02"), end.Format("2006-01-02"))
+    ctx.Header("Content-Type", "text/csv; charset=utf-8")
+    ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+    ctx.Status(200)
+
+    writer := csv.NewWriter(ctx.Writer)
+    writer.Comma = options.Delimiter
+    writer.Write(csvExportHeader)
+    for _, period := range(data.WorkPeriods) {
+        writer.Write(workPeriodRecord(ctx.Request.Context(), period, options))
+        if options.IncludeBreaks {
+            for _, breakPeriod := range(period.Breaks) {
+                writer.Write(breakPeriodRecord(period, breakPeriod, options))
+            }
+        }
+        // flush rows to client after every period
+        writer.Flush()
+    }
+    if err := writer.Error(); err != nil {
+        log.Error(fmt.Errorf("unable to write CSV export: %v", err))
+    }
+}
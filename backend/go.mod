@@ -3,9 +3,19 @@ module github.com/PSauerborn/go-timesheets
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/PSauerborn/jaeger-negroni v0.0.0-20200925213743-06d9f2368d28
 	github.com/gin-gonic/gin v1.6.3
 	github.com/google/uuid v1.1.2
 	github.com/jackc/pgx/v4 v4.8.1
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/opentracing/opentracing-go v1.2.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.6.0
+	github.com/uber/jaeger-client-go v2.23.1+incompatible
+	go.opentelemetry.io/otel v0.13.0
+	go.opentelemetry.io/otel/bridge/opentracing v0.13.0
+	go.opentelemetry.io/otel/exporters/otlp v0.13.0
+	go.opentelemetry.io/otel/sdk v0.13.0
+	gopkg.in/yaml.v2 v2.2.8
 )
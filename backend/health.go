@@ -0,0 +1,100 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    DependencyUp = "up"
+    DependencyDown = "down"
+)
+
+// define function used to check a single dependency of the service
+type DependencyCheck func(ctx context.Context) error
+
+// function used to retrieve all dependencies checked for readiness.
+// postgres is always checked, while the JWKS is only checked if keys
+// are used to validate bearer tokens
+func getDependencyChecks() map[string]DependencyCheck {
+    checks := map[string]DependencyCheck{
+        "postgres": func(ctx context.Context) error {
+            db := getPersistence()
+            if db == nil {
+                return fmt.Errorf("not connected")
+            }
+            return db.ping(ctx)
+        },
+    }
+    if AuthMode == "jwt" && (len(JWKSUrl) > 0 || len(JWKSFile) > 0) {
+        checks["jwks"] = func(ctx context.Context) error {
+            return jwks.check()
+        }
+    }
+    return checks
+}
+
+// function used to execute dependency check with configured timeout
+func checkDependency(check DependencyCheck) models.DependencyStatus {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Duration(HealthCheckTimeout) * time.Second)
+    defer cancel()
+    start := time.Now()
+    err := check(ctx)
+    status := models.DependencyStatus{Status: DependencyUp, Latency: float64(time.Since(start).Microseconds()) / 1000}
+    if err != nil {
+        status.Status, status.Error = DependencyDown, err.Error()
+    }
+    return status
+}
+
+// handler function used for liveness checks. the service is considered
+// alive as long as it is able to serve requests, regardless of the
+// status of its dependencies
+func livenessHandler(ctx *gin.Context) {
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "status": DependencyUp})
+}
+
+// handler function used for readiness checks. all dependencies are checked
+// concurrently, and a 503 response is returned if any dependency is down
+// or the service is not ready to serve requests
+func readinessHandler(ctx *gin.Context) {
+    checks := getDependencyChecks()
+    results := make(chan struct{ name string; status models.DependencyStatus }, len(checks))
+    for name, check := range(checks) {
+        go func(name string, check DependencyCheck) {
+            results <- struct{ name string; status models.DependencyStatus }{name, checkDependency(check)}
+        }(name, check)
+    }
+
+    report := models.ReadinessReport{Status: DependencyUp, Dependencies: map[string]models.DependencyStatus{}}
+    if !isReady() {
+        report.Status = DependencyDown
+    }
+    for range(checks) {
+        result := <-results
+        report.Dependencies[result.name] = result.status
+        if result.status.Status == DependencyDown {
+            log.Warn(fmt.Sprintf("readiness check for dependency %s failed: %s", result.name, result.status.Error))
+            report.Status = DependencyDown
+        }
+    }
+    code := 200
+    if report.Status == DependencyDown {
+        code = 503
+    }
+    ctx.JSON(code, gin.H{"success": code == 200, "http_code": code, "payload": report})
+}
+
+// ###########################################################
+// # Define persistence functions used to check dependencies
+// ###########################################################
+
+// function used to check that postgres is reachable
+func(db Persistence) ping(ctx context.Context) error {
+    _, err := db.conn.Exec(ctx, "SELECT 1")
+    return err
+}
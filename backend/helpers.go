@@ -4,6 +4,7 @@ import (
     "fmt"
     "time"
     "errors"
+    "context"
     "github.com/jackc/pgx/v4"
     "github.com/google/uuid"
     log "github.com/sirupsen/logrus"
@@ -15,8 +16,8 @@ var (
 
 // function used to evaluate if a given work period ID
 // is valid or not
-func isValidWorkPeriod(periodId uuid.UUID) (bool, error) {
-    _, err := persistence.getWorkPeriod(periodId)
+func isValidWorkPeriod(ctx context.Context, periodId uuid.UUID) (bool, error) {
+    _, err := persistence.getWorkPeriod(ctx, periodId)
     if err != nil {
         switch err {
         case pgx.ErrNoRows:
@@ -30,8 +31,8 @@ func isValidWorkPeriod(periodId uuid.UUID) (bool, error) {
 
 // function used to evaluate if a given break period ID
 // is valid or not
-func isValidBreakPeriod(breakId uuid.UUID) (bool, error) {
-    _, err := persistence.getBreakPeriod(breakId)
+func isValidBreakPeriod(ctx context.Context, breakId uuid.UUID) (bool, error) {
+    _, err := persistence.getBreakPeriod(ctx, breakId)
     if err != nil {
         switch err {
         case pgx.ErrNoRows:
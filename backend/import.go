@@ -0,0 +1,419 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "time"
+    "sort"
+    "errors"
+    "context"
+    "strings"
+    "net/http"
+    "encoding/csv"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    MaxImportSize int64 = 10 << 20
+
+    importParsers = map[string]RecordParser{
+        "generic": GenericRecordParser{},
+        "toggl": TogglRecordParser{},
+        "clockify": ClockifyRecordParser{},
+    }
+)
+
+type ImportOptions struct {
+    Location   *time.Location
+    TimeFormat string
+}
+
+// define struct used to store a single parsed import record. records
+// either describe a work period or a break belonging to a work period
+type ImportRecord struct {
+    Row       int
+    IsBreak   bool
+    PeriodRef string
+    Start     time.Time
+    End       time.Time
+}
+
+// define interface used to parse records from the CSV exports of
+// different time trackers into import records
+type RecordParser interface {
+    RequiredColumns() []string
+    Parse(columns map[string]int, record []string, options ImportOptions) (ImportRecord, error)
+}
+
+// define parser for generic CSV files. files require start and end columns,
+// and breaks can be given using the type and period_id columns, meaning that
+// files generated by the CSV export can be imported again
+type GenericRecordParser struct{}
+
+func(parser GenericRecordParser) RequiredColumns() []string {
+    return []string{"start", "end"}
+}
+
+func(parser GenericRecordParser) Parse(columns map[string]int, record []string, options ImportOptions) (ImportRecord, error) {
+    start, err := parseImportTime(getColumn(columns, record, "start"), options, options.TimeFormat, time.RFC3339)
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid start: %v", err)
+    }
+    end, err := parseImportTime(getColumn(columns, record, "end"), options, options.TimeFormat, time.RFC3339)
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid end: %v", err)
+    }
+    return ImportRecord{
+        IsBreak: getColumn(columns, record, "type") == "break_period",
+        PeriodRef: getColumn(columns, record, "period_id"),
+        Start: start,
+        End: end,
+    }, nil
+}
+
+// define parser for Toggl Track detailed CSV exports
+type TogglRecordParser struct{}
+
+func(parser TogglRecordParser) RequiredColumns() []string {
+    return []string{"start date", "start time", "end date", "end time"}
+}
+
+func(parser TogglRecordParser) Parse(columns map[string]int, record []string, options ImportOptions) (ImportRecord, error) {
+    start, err := parseImportTime(getColumn(columns, record, "start date") + " " + getColumn(columns, record, "start time"), options, "2006-01-02 15:04:05")
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid start: %v", err)
+    }
+    end, err := parseImportTime(getColumn(columns, record, "end date") + " " + getColumn(columns, record, "end time"), options, "2006-01-02 15:04:05")
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid end: %v", err)
+    }
+    return ImportRecord{Start: start, End: end}, nil
+}
+
+// define parser for Clockify detailed CSV exports. clockify exports
+// dates and times in either 12 or 24 hour format depending on settings
+type ClockifyRecordParser struct{}
+
+func(parser ClockifyRecordParser) RequiredColumns() []string {
+    return []string{"start date", "start time", "end date", "end time"}
+}
+
+func(parser ClockifyRecordParser) Parse(columns map[string]int, record []string, options ImportOptions) (ImportRecord, error) {
+    layouts := []string{"01/02/2006 03:04:05 PM", "01/02/2006 15:04:05", "01/02/2006 03:04 PM", "01/02/2006 15:04"}
+    start, err := parseImportTime(getColumn(columns, record, "start date") + " " + getColumn(columns, record, "start time"), options, layouts...)
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid start: %v", err)
+    }
+    end, err := parseImportTime(getColumn(columns, record, "end date") + " " + getColumn(columns, record, "end time"), options, layouts...)
+    if err != nil {
+        return ImportRecord{}, fmt.Errorf("invalid end: %v", err)
+    }
+    return ImportRecord{Start: start, End: end}, nil
+}
+
+// function used to retrieve value of named column from record
+func getColumn(columns map[string]int, record []string, name string) string {
+    if index, ok := columns[name]; ok && index < len(record) {
+        return strings.TrimSpace(record[index])
+    }
+    return ""
+}
+
+// function used to parse timestamp using the first matching layout.
+// timestamps without timezone information are parsed in the import timezone
+func parseImportTime(value string, options ImportOptions, layouts ...string) (time.Time, error) {
+    value = strings.TrimSpace(value)
+    for _, layout := range(layouts) {
+        if len(layout) == 0 {
+            continue
+        }
+        if t, err := time.ParseInLocation(layout, value, options.Location); err == nil {
+            return t.UTC(), nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("unable to parse timestamp '%s'", value)
+}
+
+// function used to read CSV records from import file and parse them using
+// the given parser. errors are collected per row rather than aborting
+func parseImportFile(reader io.Reader, parser RecordParser, options ImportOptions) ([]ImportRecord, []models.ImportError, error) {
+    csvReader := csv.NewReader(reader)
+    csvReader.FieldsPerRecord = -1
+    header, err := csvReader.Read()
+    if err != nil {
+        return nil, nil, fmt.Errorf("unable to read header: %v", err)
+    }
+    // convert header into map of column name to index
+    columns := map[string]int{}
+    for index, name := range(header) {
+        columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = index
+    }
+    for _, column := range(parser.RequiredColumns()) {
+        if _, ok := columns[column]; !ok {
+            return nil, nil, fmt.Errorf("missing required column '%s'", column)
+        }
+    }
+
+    records, importErrors := []ImportRecord{}, []models.ImportError{}
+    for row := 2; ; row++ {
+        values, err := csvReader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            importErrors = append(importErrors, models.ImportError{Row: row, Message: err.Error()})
+            continue
+        }
+        record, err := parser.Parse(columns, values, options)
+        if err != nil {
+            importErrors = append(importErrors, models.ImportError{Row: row, Message: err.Error()})
+            continue
+        }
+        if !record.End.After(record.Start) {
+            importErrors = append(importErrors, models.ImportError{Row: row, Message: "end must be after start"})
+            continue
+        }
+        record.Row = row
+        records = append(records, record)
+    }
+    return records, importErrors, nil
+}
+
+// function used to convert import records into work periods. breaks are
+// attached to the work periods they reference, and work periods and breaks
+// are validated against each other for overlaps. the row of each imported
+// period and break is returned so that conflicts with existing periods,
+// which are checked when the periods are inserted, can be reported
+func buildImportPeriods(records []ImportRecord) ([]models.WorkPeriod, map[uuid.UUID]int, []models.ImportError) {
+    periods, importErrors := []models.WorkPeriod{}, []models.ImportError{}
+    rows, references := map[uuid.UUID]int{}, map[string]int{}
+    for _, record := range(records) {
+        if record.IsBreak {
+            continue
+        }
+        end := record.End
+        period := models.WorkPeriod{PeriodId: uuid.New(), CreatedAt: record.Start, FinishedAt: &end, Breaks: []models.BreakPeriod{}}
+        if len(record.PeriodRef) > 0 {
+            references[record.PeriodRef] = len(periods)
+        }
+        rows[period.PeriodId] = record.Row
+        periods = append(periods, period)
+    }
+    // attach breaks to referenced work periods
+    for _, record := range(records) {
+        if !record.IsBreak {
+            continue
+        }
+        index, ok := references[record.PeriodRef]
+        if !ok {
+            importErrors = append(importErrors, models.ImportError{Row: record.Row, Message: fmt.Sprintf("unknown work period '%s'", record.PeriodRef)})
+            continue
+        }
+        period := &periods[index]
+        if record.Start.Before(period.CreatedAt) || record.End.After(*period.FinishedAt) {
+            importErrors = append(importErrors, models.ImportError{Row: record.Row, Message: "break must be within work period"})
+            continue
+        }
+        end := record.End
+        breakPeriod := models.BreakPeriod{BreakId: uuid.New(), CreatedAt: record.Start, FinishedAt: &end}
+        rows[breakPeriod.BreakId] = record.Row
+        period.Breaks = append(period.Breaks, breakPeriod)
+    }
+
+    // validate imported periods and breaks against each other
+    sort.Slice(periods, func(i, j int) bool { return periods[i].CreatedAt.Before(periods[j].CreatedAt) })
+    for i := range(periods) {
+        if i > 0 && periods[i].CreatedAt.Before(*periods[i - 1].FinishedAt) {
+            importErrors = append(importErrors, models.ImportError{Row: rows[periods[i].PeriodId], Message: fmt.Sprintf("overlaps with row %d", rows[periods[i - 1].PeriodId])})
+        }
+        breaks := periods[i].Breaks
+        sort.Slice(breaks, func(a, b int) bool { return breaks[a].CreatedAt.Before(breaks[b].CreatedAt) })
+        for j := 1; j < len(breaks); j++ {
+            if breaks[j].CreatedAt.Before(*breaks[j - 1].FinishedAt) {
+                importErrors = append(importErrors, models.ImportError{Row: rows[breaks[j].BreakId], Message: fmt.Sprintf("overlaps with break in row %d", rows[breaks[j - 1].BreakId])})
+            }
+        }
+    }
+    return periods, rows, importErrors
+}
+
+// function used to read import file from request. files can either be
+// uploaded as multipart forms or sent directly as the request body
+func getImportReader(ctx *gin.Context) (io.ReadCloser, error) {
+    ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxImportSize)
+    if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+        file, _, err := ctx.Request.FormFile("file")
+        if err != nil {
+            return nil, errors.New("missing import file")
+        }
+        return file, nil
+    }
+    return ctx.Request.Body, nil
+}
+
+// function used to import historical data for the current user. files are
+// parsed and validated in full before any periods are inserted, and all
+// periods are inserted in a single transaction. the dry_run query parameter
+// can be used to preview the imported periods along with any errors
+func importUserDataHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    format := strings.ToLower(ctx.DefaultQuery("format", "generic"))
+    parser, ok := importParsers[format]
+    if !ok {
+        StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("unsupported import format %s", format))
+        return
+    }
+    location, err := time.LoadLocation(ctx.DefaultQuery("timezone", "UTC"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timezone")
+        return
+    }
+    options := ImportOptions{Location: location, TimeFormat: ctx.DefaultQuery("time_format", "datetime")}
+    if layout, ok := exportTimeFormats[options.TimeFormat]; ok {
+        options.TimeFormat = layout
+    }
+    dryRun := strings.ToLower(ctx.DefaultQuery("dry_run", "false")) == "true"
+
+    log.Debug(fmt.Sprintf("received request to import %s data for user %s", format, user))
+    reader, err := getImportReader(ctx)
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, err.Error())
+        return
+    }
+    defer reader.Close()
+    records, importErrors, err := parseImportFile(reader, parser, options)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse import file: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, err.Error())
+        return
+    }
+    periods, rows, validationErrors := buildImportPeriods(records)
+    importErrors = append(importErrors, validationErrors...)
+    // validate periods against existing periods and approved weeks. periods
+    // are only inserted if the import is valid and not a dry run
+    commit := !dryRun && len(importErrors) == 0
+    conflicts, err := persistence.importWorkPeriods(ctx.Request.Context(), user, periods, commit)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to import work periods for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    for _, conflict := range(conflicts) {
+        importErrors = append(importErrors, models.ImportError{Row: rows[conflict.PeriodId], Message: conflict.Message})
+    }
+    sort.SliceStable(importErrors, func(i, j int) bool { return importErrors[i].Row < importErrors[j].Row })
+
+    results := analysePeriods(ctx.Request.Context(), periods)
+    preview := models.ImportPreview{
+        DryRun: dryRun,
+        TotalPeriods: results.TotalPeriods,
+        TotalBreaks: results.TotalBreaks,
+        Periods: periods,
+        Errors: importErrors,
+    }
+    if dryRun {
+        ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": preview})
+        return
+    }
+    if len(importErrors) > 0 {
+        ctx.AbortWithStatusJSON(400, gin.H{"success": false, "http_code": 400, "message": "import file contains errors", "payload": preview})
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": preview})
+}
+
+// ###########################################################
+// # Define persistence functions used to import data
+// ###########################################################
+
+// define struct used to describe an imported work period that
+// conflicts with existing periods or approved weeks
+type ImportConflict struct {
+    PeriodId uuid.UUID
+    Message  string
+}
+
+// function used to insert list of work periods and breaks for a user.
+// all periods are inserted within a single transaction. the user is locked
+// before periods are checked against existing periods and approved weeks,
+// and nothing is inserted if conflicts are found or commit is not set. the
+// regular events are recorded for all imported periods and breaks
+func(db Persistence) importWorkPeriods(ctx context.Context, uid string, periods []models.WorkPeriod, commit bool) ([]ImportConflict, error) {
+    logger(ctx).Debug(fmt.Sprintf("importing %d work periods for user %s", len(periods), uid))
+    conflicts := []ImportConflict{}
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return conflicts, err
+    }
+    defer tx.Rollback(ctx)
+    // lock user so that periods are not created or approved during import
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock user %s: %v", uid, err))
+        return conflicts, err
+    }
+
+    for _, period := range(periods) {
+        switch err := lockTimesheetWeek(ctx, tx, uid, weekStart(period.CreatedAt)); err {
+        case nil:
+        case ErrWeekLocked:
+            conflicts = append(conflicts, ImportConflict{PeriodId: period.PeriodId, Message: err.Error()})
+        default:
+            return conflicts, err
+        }
+        // open periods are treated as ongoing when checking for overlaps
+        rows, err := tx.Query(ctx, `SELECT period_id FROM work_periods WHERE uid=$1
+            AND tstzrange(created_at, COALESCE(finished_at, 'infinity')) && tstzrange($2, $3)`, uid, period.CreatedAt, period.FinishedAt)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to check for overlapping work periods: %v", err))
+            return conflicts, err
+        }
+        for rows.Next() {
+            var existing uuid.UUID
+            if err := rows.Scan(&existing); err != nil {
+                rows.Close()
+                logger(ctx).Error(fmt.Errorf("unable to process work period: %v", err))
+                return conflicts, err
+            }
+            conflicts = append(conflicts, ImportConflict{PeriodId: period.PeriodId, Message: fmt.Sprintf("overlaps with existing work period %s", existing)})
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to check for overlapping work periods: %v", err))
+            return conflicts, err
+        }
+    }
+    if len(conflicts) > 0 || !commit {
+        return conflicts, nil
+    }
+
+    for _, period := range(periods) {
+        _, err := tx.Exec(ctx, "INSERT INTO work_periods(period_id, uid, created_at, finished_at) VALUES($1,$2,$3,$4)",
+            period.PeriodId, uid, period.CreatedAt, period.FinishedAt)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to import work period: %v", err))
+            return conflicts, err
+        }
+        for _, breakPeriod := range(period.Breaks) {
+            _, err := tx.Exec(ctx, "INSERT INTO break_periods(break_id, period_id, created_at, finished_at) VALUES($1,$2,$3,$4)",
+                breakPeriod.BreakId, period.PeriodId, breakPeriod.CreatedAt, breakPeriod.FinishedAt)
+            if err != nil {
+                logger(ctx).Error(fmt.Errorf("unable to import break period: %v", err))
+                return conflicts, err
+            }
+        }
+        if err := recordWrittenWorkPeriodEvents(ctx, tx, uid, period); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to record imported work period events: %v", err))
+            return conflicts, err
+        }
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit import: %v", err))
+        return conflicts, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully imported %d work periods for user %s", len(periods), uid))
+    return conflicts, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "sync"
+    "errors"
+    "strings"
+    "math/big"
+    "net/http"
+    "io/ioutil"
+    "crypto"
+    "crypto/rsa"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/json"
+    "encoding/base64"
+    "github.com/gin-gonic/gin"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    ErrInvalidToken = errors.New("invalid token")
+    ErrExpiredToken = errors.New("token has expired")
+    ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+    ErrUnknownKey = errors.New("unknown signing key")
+
+    authenticators = map[string]Authenticator{
+        "header": HeaderAuthenticator{},
+        "jwt": JWTAuthenticator{},
+    }
+
+    jwks = &JSONWebKeySet{keys: map[string]*rsa.PublicKey{}}
+)
+
+// define interface used to authenticate incoming requests. authenticators
+// return the ID of the authenticated user along with any roles that
+// are provided by the authentication mechanism itself
+type Authenticator interface {
+    Authenticate(ctx *gin.Context) (string, []string, error)
+}
+
+// define authenticator used to read user ID from the header set by an
+// upstream gateway. the gateway is responsible for authenticating the user
+type HeaderAuthenticator struct{}
+
+func(authenticator HeaderAuthenticator) Authenticate(ctx *gin.Context) (string, []string, error) {
+    return ctx.Request.Header.Get("X-Authenticated-Userid"), []string{}, nil
+}
+
+// define authenticator used to validate bearer tokens in the authorization
+// header. user ID and roles are extracted from the configured claims
+type JWTAuthenticator struct{}
+
+func(authenticator JWTAuthenticator) Authenticate(ctx *gin.Context) (string, []string, error) {
+    header := ctx.Request.Header.Get("Authorization")
+    if !strings.HasPrefix(header, "Bearer ") {
+        return "", []string{}, nil
+    }
+    claims, err := parseJWT(strings.TrimPrefix(header, "Bearer "))
+    if err != nil {
+        return "", []string{}, err
+    }
+    uid, _ := getClaim(claims, JWTUidClaim).(string)
+    return uid, getClaimList(claims, JWTRolesClaim), nil
+}
+
+// define resolver used to assign roles from the claims of the token
+// used to authenticate the request
+type TokenRoleResolver struct{}
+
+func(resolver TokenRoleResolver) ResolveRoles(ctx *gin.Context, uid string) ([]string, error) {
+    if roles, ok := ctx.Get("tokenRoles"); ok {
+        return append(roles.([]string), DefaultRoles...), nil
+    }
+    return DefaultRoles, nil
+}
+
+// define struct used to store the RSA keys used to verify tokens. keys
+// are loaded from a JWKS file or URL and reloaded when an unknown key
+// ID is encountered, allowing for keys to be rotated
+type JSONWebKeySet struct {
+    mutex      sync.RWMutex
+    keys       map[string]*rsa.PublicKey
+    lastLoaded time.Time
+}
+
+type JSONWebKey struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    N   string `json:"n"`
+    E   string `json:"e"`
+}
+
+// function used to retrieve key from key set. if the key is unknown, the
+// key set is reloaded at most once per configured refresh interval
+func(keySet *JSONWebKeySet) getKey(kid string) (*rsa.PublicKey, error) {
+    keySet.mutex.RLock()
+    key, ok := keySet.keys[kid]
+    stale := time.Since(keySet.lastLoaded) > time.Duration(JWKSRefreshInterval) * time.Second
+    keySet.mutex.RUnlock()
+    if ok {
+        return key, nil
+    }
+    if !stale {
+        return nil, ErrUnknownKey
+    }
+    if err := keySet.load(); err != nil {
+        return nil, err
+    }
+    keySet.mutex.RLock()
+    defer keySet.mutex.RUnlock()
+    if key, ok := keySet.keys[kid]; ok {
+        return key, nil
+    }
+    return nil, ErrUnknownKey
+}
+
+// function used to load RSA keys from configured JWKS file or URL
+func(keySet *JSONWebKeySet) load() error {
+    keySet.mutex.Lock()
+    defer keySet.mutex.Unlock()
+    keySet.lastLoaded = time.Now()
+
+    var (body []byte; err error)
+    if len(JWKSUrl) > 0 {
+        log.Debug(fmt.Sprintf("loading JWKS from url %s", JWKSUrl))
+        body, err = fetchJWKS(JWKSUrl)
+    } else {
+        log.Debug(fmt.Sprintf("loading JWKS from file %s", JWKSFile))
+        body, err = ioutil.ReadFile(JWKSFile)
+    }
+    if err != nil {
+        log.Error(fmt.Errorf("unable to load JWKS: %v", err))
+        return err
+    }
+
+    var keySetJSON struct { Keys []JSONWebKey `json:"keys"` }
+    if err := json.Unmarshal(body, &keySetJSON); err != nil {
+        log.Error(fmt.Errorf("unable to parse JWKS: %v", err))
+        return err
+    }
+    keys := map[string]*rsa.PublicKey{}
+    for _, key := range(keySetJSON.Keys) {
+        if key.Kty != "RSA" {
+            continue
+        }
+        n, errN := base64.RawURLEncoding.DecodeString(key.N)
+        e, errE := base64.RawURLEncoding.DecodeString(key.E)
+        if errN != nil || errE != nil {
+            log.Warn(fmt.Sprintf("skipping invalid JWKS key %s", key.Kid))
+            continue
+        }
+        keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+    }
+    log.Info(fmt.Sprintf("successfully loaded %d keys from JWKS", len(keys)))
+    keySet.keys = keys
+    return nil
+}
+
+// function used to check that key set contains keys. empty key sets
+// are reloaded at most once per configured refresh interval
+func(keySet *JSONWebKeySet) check() error {
+    keySet.mutex.RLock()
+    size := len(keySet.keys)
+    stale := time.Since(keySet.lastLoaded) > time.Duration(JWKSRefreshInterval) * time.Second
+    keySet.mutex.RUnlock()
+    if size > 0 {
+        return nil
+    }
+    if stale {
+        if err := keySet.load(); err != nil {
+            return err
+        }
+        keySet.mutex.RLock()
+        size = len(keySet.keys)
+        keySet.mutex.RUnlock()
+    }
+    if size == 0 {
+        return errors.New("key set does not contain any keys")
+    }
+    return nil
+}
+
+// function used to fetch JWKS from remote URL
+func fetchJWKS(url string) ([]byte, error) {
+    client := http.Client{Timeout: 10 * time.Second}
+    response, err := client.Get(url)
+    if err != nil {
+        return nil, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != 200 {
+        return nil, fmt.Errorf("received invalid response code %d", response.StatusCode)
+    }
+    return ioutil.ReadAll(response.Body)
+}
+
+// function used to parse and validate JWT. the signature is verified
+// using either the configured HS256 secret or RS256 keys from the JWKS,
+// and the expiry, not before, issuer and audience claims are validated
+func parseJWT(token string) (map[string]interface{}, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, ErrInvalidToken
+    }
+    var header struct { Alg string `json:"alg"`; Kid string `json:"kid"` }
+    if err := decodeJWTSegment(parts[0], &header); err != nil {
+        return nil, ErrInvalidToken
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    if err := verifyJWTSignature(header.Alg, header.Kid, parts[0] + "." + parts[1], signature); err != nil {
+        return nil, err
+    }
+
+    claims := map[string]interface{}{}
+    if err := decodeJWTSegment(parts[1], &claims); err != nil {
+        return nil, ErrInvalidToken
+    }
+    if err := validateJWTClaims(claims); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+// function used to decode base64 encoded JSON segment of JWT
+func decodeJWTSegment(segment string, target interface{}) error {
+    body, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(body, target)
+}
+
+// function used to verify signature of JWT for the given algorithm
+func verifyJWTSignature(alg, kid, signingInput string, signature []byte) error {
+    switch alg {
+    case "HS256":
+        if len(JWTSecret) == 0 {
+            return ErrUnsupportedAlgorithm
+        }
+        mac := hmac.New(sha256.New, []byte(JWTSecret))
+        mac.Write([]byte(signingInput))
+        if !hmac.Equal(mac.Sum(nil), signature) {
+            return ErrInvalidToken
+        }
+        return nil
+    case "RS256":
+        if len(JWKSUrl) == 0 && len(JWKSFile) == 0 {
+            return ErrUnsupportedAlgorithm
+        }
+        key, err := jwks.getKey(kid)
+        if err != nil {
+            return err
+        }
+        hashed := sha256.Sum256([]byte(signingInput))
+        if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+            return ErrInvalidToken
+        }
+        return nil
+    default:
+        return ErrUnsupportedAlgorithm
+    }
+}
+
+// function used to validate registered claims of JWT
+func validateJWTClaims(claims map[string]interface{}) error {
+    now := float64(time.Now().Unix())
+    leeway := float64(JWTLeeway)
+    // tokens without expiry are rejected since they cannot be revoked
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return ErrInvalidToken
+    }
+    if now > exp + leeway {
+        return ErrExpiredToken
+    }
+    if nbf, ok := claims["nbf"].(float64); ok && now < nbf - leeway {
+        return ErrInvalidToken
+    }
+    if len(JWTIssuer) > 0 && claims["iss"] != JWTIssuer {
+        return ErrInvalidToken
+    }
+    if len(JWTAudience) > 0 {
+        for _, audience := range(getClaimList(claims, "aud")) {
+            if audience == JWTAudience {
+                return nil
+            }
+        }
+        return ErrInvalidToken
+    }
+    return nil
+}
+
+// function used to retrieve claim from token. nested claims can
+// be retrieved using dot separated paths i.e. realm_access.roles
+func getClaim(claims map[string]interface{}, path string) interface{} {
+    var value interface{} = claims
+    for _, key := range(strings.Split(path, ".")) {
+        nested, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = nested[key]
+    }
+    return value
+}
+
+// function used to retrieve claim as list of strings. claims can
+// either be given as JSON arrays or as space separated strings
+func getClaimList(claims map[string]interface{}, path string) []string {
+    values := []string{}
+    switch claim := getClaim(claims, path).(type) {
+    case string:
+        values = append(values, strings.Fields(claim)...)
+    case []interface{}:
+        for _, item := range(claim) {
+            if value, ok := item.(string); ok {
+                values = append(values, value)
+            }
+        }
+    }
+    return values
+}
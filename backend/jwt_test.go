@@ -0,0 +1,203 @@
+package main
+
+import (
+    "time"
+    "testing"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+    "math/big"
+    "crypto"
+    "crypto/rsa"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/json"
+    "encoding/base64"
+)
+
+const (
+    testJWTSecret = "test-secret"
+    testJWTIssuer = "https://issuer.example.com"
+    testJWTAudience = "go-timesheets"
+    testJWTKeyId = "test-key"
+)
+
+// function used to configure JWT validation for tests. a JWKS file
+// containing the public part of the given key is written to a temp dir
+func configureTestJWT(t *testing.T, key *rsa.PrivateKey) {
+    secret, url, file, refresh := JWTSecret, JWKSUrl, JWKSFile, JWKSRefreshInterval
+    issuer, audience, leeway, keys := JWTIssuer, JWTAudience, JWTLeeway, jwks
+    t.Cleanup(func() {
+        JWTSecret, JWKSUrl, JWKSFile, JWKSRefreshInterval = secret, url, file, refresh
+        JWTIssuer, JWTAudience, JWTLeeway, jwks = issuer, audience, leeway, keys
+    })
+
+    body, err := json.Marshal(map[string]interface{}{
+        "keys": []JSONWebKey{{
+            Kty: "RSA",
+            Kid: testJWTKeyId,
+            N: base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+            E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+        }},
+    })
+    if err != nil {
+        t.Fatalf("unable to encode JWKS: %v", err)
+    }
+    path := filepath.Join(t.TempDir(), "jwks.json")
+    if err := ioutil.WriteFile(path, body, 0600); err != nil {
+        t.Fatalf("unable to write JWKS: %v", err)
+    }
+
+    JWTSecret, JWKSUrl, JWKSFile, JWKSRefreshInterval = testJWTSecret, "", path, 0
+    JWTIssuer, JWTAudience, JWTLeeway = testJWTIssuer, testJWTAudience, 30
+    jwks = &JSONWebKeySet{keys: map[string]*rsa.PublicKey{}}
+}
+
+// function used to generate signed test token. key is either a byte
+// slice used as HMAC secret or an RSA private key
+func signTestJWT(t *testing.T, header, claims map[string]interface{}, key interface{}) string {
+    encode := func(value interface{}) string {
+        body, err := json.Marshal(value)
+        if err != nil {
+            t.Fatalf("unable to encode token segment: %v", err)
+        }
+        return base64.RawURLEncoding.EncodeToString(body)
+    }
+    input := encode(header) + "." + encode(claims)
+
+    var signature []byte
+    switch key := key.(type) {
+    case []byte:
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(input))
+        signature = mac.Sum(nil)
+    case *rsa.PrivateKey:
+        hashed := sha256.Sum256([]byte(input))
+        var err error
+        if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:]); err != nil {
+            t.Fatalf("unable to sign token: %v", err)
+        }
+    }
+    return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// function used to tamper with the claims of a signed token while
+// keeping the original header and signature
+func tamperTestJWT(t *testing.T, token string, claims map[string]interface{}) string {
+    original := strings.Split(token, ".")
+    tampered := strings.Split(signTestJWT(t, map[string]interface{}{}, claims, []byte{}), ".")
+    return original[0] + "." + tampered[1] + "." + original[2]
+}
+
+func TestParseJWT(t *testing.T) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatalf("unable to generate RSA key: %v", err)
+    }
+    otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatalf("unable to generate RSA key: %v", err)
+    }
+    configureTestJWT(t, key)
+
+    now := time.Now().Unix()
+    claims := func(overrides map[string]interface{}) map[string]interface{} {
+        values := map[string]interface{}{
+            "sub": "user-1",
+            "iss": testJWTIssuer,
+            "aud": []string{"other-service", testJWTAudience},
+            "exp": now + 300,
+            "nbf": now - 60,
+        }
+        for claim, value := range(overrides) {
+            if value == nil {
+                delete(values, claim)
+            } else {
+                values[claim] = value
+            }
+        }
+        return values
+    }
+    hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+    rs256 := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": testJWTKeyId}
+    secret := []byte(testJWTSecret)
+    publicKey := key.PublicKey.N.Bytes()
+
+    tests := []struct {
+        name     string
+        token    string
+        expected error
+    }{
+        {"hs256 valid", signTestJWT(t, hs256, claims(nil), secret), nil},
+        {"hs256 wrong secret", signTestJWT(t, hs256, claims(nil), []byte("other-secret")), ErrInvalidToken},
+        {"hs256 tampered claims", tamperTestJWT(t, signTestJWT(t, hs256, claims(nil), secret),
+            claims(map[string]interface{}{"sub": "admin"})), ErrInvalidToken},
+        {"rs256 valid", signTestJWT(t, rs256, claims(nil), key), nil},
+        {"rs256 wrong key", signTestJWT(t, rs256, claims(nil), otherKey), ErrInvalidToken},
+        {"rs256 tampered claims", tamperTestJWT(t, signTestJWT(t, rs256, claims(nil), key),
+            claims(map[string]interface{}{"sub": "admin"})), ErrInvalidToken},
+        {"rs256 unknown kid", signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": "other-key"},
+            claims(nil), key), ErrUnknownKey},
+        {"rs256 missing kid", signTestJWT(t, map[string]interface{}{"alg": "RS256"}, claims(nil), key), ErrUnknownKey},
+        {"alg confusion public key as hmac secret", signTestJWT(t, map[string]interface{}{"alg": "HS256", "kid": testJWTKeyId},
+            claims(nil), publicKey), ErrInvalidToken},
+        {"alg none", signTestJWT(t, map[string]interface{}{"alg": "none"}, claims(nil), []byte{}), ErrUnsupportedAlgorithm},
+        {"alg lowercase", signTestJWT(t, map[string]interface{}{"alg": "hs256"}, claims(nil), secret), ErrUnsupportedAlgorithm},
+        {"missing exp", signTestJWT(t, hs256, claims(map[string]interface{}{"exp": nil}), secret), ErrInvalidToken},
+        {"expired", signTestJWT(t, hs256, claims(map[string]interface{}{"exp": now - 60}), secret), ErrExpiredToken},
+        {"expired within leeway", signTestJWT(t, hs256, claims(map[string]interface{}{"exp": now - 10}), secret), nil},
+        {"not yet valid", signTestJWT(t, hs256, claims(map[string]interface{}{"nbf": now + 60}), secret), ErrInvalidToken},
+        {"not yet valid within leeway", signTestJWT(t, hs256, claims(map[string]interface{}{"nbf": now + 10}), secret), nil},
+        {"wrong issuer", signTestJWT(t, hs256, claims(map[string]interface{}{"iss": "https://other.example.com"}), secret), ErrInvalidToken},
+        {"missing issuer", signTestJWT(t, hs256, claims(map[string]interface{}{"iss": nil}), secret), ErrInvalidToken},
+        {"audience string", signTestJWT(t, hs256, claims(map[string]interface{}{"aud": testJWTAudience}), secret), nil},
+        {"wrong audience", signTestJWT(t, hs256, claims(map[string]interface{}{"aud": []string{"other-service"}}), secret), ErrInvalidToken},
+        {"missing audience", signTestJWT(t, hs256, claims(map[string]interface{}{"aud": nil}), secret), ErrInvalidToken},
+        {"malformed", "not-a-token", ErrInvalidToken},
+    }
+
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            parsed, err := parseJWT(test.token)
+            if err != test.expected {
+                t.Fatalf("expected error %v, got %v", test.expected, err)
+            }
+            if test.expected == nil && parsed["sub"] != "user-1" {
+                t.Fatalf("expected subject user-1, got %v", parsed["sub"])
+            }
+        })
+    }
+}
+
+func TestParseJWTUnconfiguredAlgorithm(t *testing.T) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatalf("unable to generate RSA key: %v", err)
+    }
+    configureTestJWT(t, key)
+    claims := map[string]interface{}{"sub": "user-1", "iss": testJWTIssuer, "aud": testJWTAudience,
+        "exp": time.Now().Unix() + 300}
+
+    tests := []struct {
+        name      string
+        configure func()
+        token     string
+    }{
+        {"hs256 without secret", func() { JWTSecret = "" },
+            signTestJWT(t, map[string]interface{}{"alg": "HS256"}, claims, []byte(""))},
+        {"rs256 without key set", func() { JWKSFile = "" },
+            signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": testJWTKeyId}, claims, key)},
+    }
+
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            secret, file := JWTSecret, JWKSFile
+            defer func() { JWTSecret, JWKSFile = secret, file }()
+            test.configure()
+            if _, err := parseJWT(test.token); err != ErrUnsupportedAlgorithm {
+                t.Fatalf("expected error %v, got %v", ErrUnsupportedAlgorithm, err)
+            }
+        })
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "time"
+    "context"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    RequestIdHeader = "X-Request-ID"
+
+    logFormatters = map[string]log.Formatter{
+        "text": &log.TextFormatter{FullTimestamp: true},
+        "json": &log.JSONFormatter{TimestampFormat: time.RFC3339Nano},
+    }
+)
+
+type requestMetadataKey struct {}
+
+// define struct used to store metadata of a request in the request context.
+// the user ID is only known once the request has been authenticated, and
+// is therefore set by reference after the metadata has been stored
+type RequestMetadata struct {
+    RequestId string
+    Uid       string
+}
+
+// function used to retrieve request metadata from context
+func getRequestMetadata(ctx context.Context) *RequestMetadata {
+    if metadata, ok := ctx.Value(requestMetadataKey{}).(*RequestMetadata); ok {
+        return metadata
+    }
+    return nil
+}
+
+// function used to retrieve log entry for a given context. entries
+// created for requests carry the request ID and user ID as fields
+func logger(ctx context.Context) *log.Entry {
+    metadata := getRequestMetadata(ctx)
+    if metadata == nil {
+        return log.NewEntry(log.StandardLogger())
+    }
+    fields := log.Fields{"request_id": metadata.RequestId}
+    if len(metadata.Uid) > 0 {
+        fields["uid"] = metadata.Uid
+    }
+    return log.WithFields(fields)
+}
+
+// function used to assign request ID to all requests and write a single
+// access log entry once the request is completed. request IDs sent by
+// clients or upstream proxies are propagated, and new IDs are generated
+// otherwise
+func requestLogger() gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        start := time.Now()
+        requestId := ctx.GetHeader(RequestIdHeader)
+        if len(requestId) == 0 || len(requestId) > 128 {
+            requestId = uuid.New().String()
+        }
+        metadata := &RequestMetadata{RequestId: requestId}
+        ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), requestMetadataKey{}, metadata))
+        ctx.Set("requestId", requestId)
+        ctx.Header(RequestIdHeader, requestId)
+
+        ctx.Next()
+
+        route := ctx.FullPath()
+        if len(route) == 0 {
+            route = "unmatched"
+        }
+        entry := logger(ctx.Request.Context()).WithFields(log.Fields{
+            "method": ctx.Request.Method,
+            "route": route,
+            "path": ctx.Request.URL.Path,
+            "status": ctx.Writer.Status(),
+            "latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+            "bytes": ctx.Writer.Size(),
+            "client_ip": ctx.ClientIP(),
+        })
+        if len(ctx.Errors) > 0 {
+            entry = entry.WithField("errors", ctx.Errors.String())
+        }
+        entry.Info("request completed")
+    }
+}
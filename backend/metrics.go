@@ -0,0 +1,141 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/prometheus/client_golang/prometheus"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    MetricsNamespace = "go_timesheets"
+
+    httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: MetricsNamespace,
+        Name: "http_requests_total",
+        Help: "Total number of HTTP requests by method, route and status.",
+    }, []string{"method", "route", "status"})
+
+    httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: MetricsNamespace,
+        Name: "http_request_duration_seconds",
+        Help: "Latency of HTTP requests by method, route and status.",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"method", "route", "status"})
+
+    backgroundJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: MetricsNamespace,
+        Name: "background_jobs_total",
+        Help: "Total number of background job runs by job and outcome.",
+    }, []string{"job", "outcome"})
+)
+
+func init() {
+    prometheus.MustRegister(httpRequests, httpRequestDuration, backgroundJobs, PoolCollector{}, ActivityCollector{})
+}
+
+// function used to record outcome of background job
+func recordJobOutcome(job, outcome string) {
+    backgroundJobs.WithLabelValues(job, outcome).Inc()
+}
+
+// function used to instrument all requests. requests are labelled using
+// the route template rather than the URL, meaning that path parameters
+// such as period IDs do not create new time series
+func instrumentRequests() gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        start := time.Now()
+        ctx.Next()
+        route := ctx.FullPath()
+        if len(route) == 0 {
+            route = "unmatched"
+        }
+        status := strconv.Itoa(ctx.Writer.Status())
+        httpRequests.WithLabelValues(ctx.Request.Method, route, status).Inc()
+        httpRequestDuration.WithLabelValues(ctx.Request.Method, route, status).Observe(time.Since(start).Seconds())
+    }
+}
+
+// define collector used to expose statistics of the pgx connection pool
+type PoolCollector struct {}
+
+var (
+    poolAcquiredConns = prometheus.NewDesc(MetricsNamespace + "_pool_acquired_connections", "Number of currently acquired connections in the pool.", nil, nil)
+    poolIdleConns = prometheus.NewDesc(MetricsNamespace + "_pool_idle_connections", "Number of currently idle connections in the pool.", nil, nil)
+    poolTotalConns = prometheus.NewDesc(MetricsNamespace + "_pool_total_connections", "Total number of connections in the pool.", nil, nil)
+    poolMaxConns = prometheus.NewDesc(MetricsNamespace + "_pool_max_connections", "Maximum size of the pool.", nil, nil)
+    poolAcquires = prometheus.NewDesc(MetricsNamespace + "_pool_acquires_total", "Total number of successful acquires from the pool.", nil, nil)
+    poolEmptyAcquires = prometheus.NewDesc(MetricsNamespace + "_pool_empty_acquires_total", "Total number of acquires that waited for a connection.", nil, nil)
+    poolCanceledAcquires = prometheus.NewDesc(MetricsNamespace + "_pool_canceled_acquires_total", "Total number of acquires cancelled by a context.", nil, nil)
+    poolAcquireDuration = prometheus.NewDesc(MetricsNamespace + "_pool_acquire_duration_seconds_total", "Total time spent acquiring connections from the pool.", nil, nil)
+)
+
+func(collector PoolCollector) Describe(descs chan<- *prometheus.Desc) {
+    for _, desc := range([]*prometheus.Desc{poolAcquiredConns, poolIdleConns, poolTotalConns, poolMaxConns, poolAcquires, poolEmptyAcquires, poolCanceledAcquires, poolAcquireDuration}) {
+        descs <- desc
+    }
+}
+
+func(collector PoolCollector) Collect(metrics chan<- prometheus.Metric) {
+    db := getPersistence()
+    if db == nil {
+        return
+    }
+    stat := db.conn.Stat()
+    metrics <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+    metrics <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+    metrics <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+    metrics <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+    metrics <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+    metrics <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+    metrics <- prometheus.MustNewConstMetric(poolCanceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+    metrics <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// define collector used to expose the number of currently active work
+// and break periods. counts are queried whenever metrics are scraped
+type ActivityCollector struct {}
+
+var (
+    activeWorkPeriods = prometheus.NewDesc(MetricsNamespace + "_active_work_periods", "Number of currently active work periods.", nil, nil)
+    activeBreakPeriods = prometheus.NewDesc(MetricsNamespace + "_active_break_periods", "Number of currently active break periods.", nil, nil)
+)
+
+func(collector ActivityCollector) Describe(descs chan<- *prometheus.Desc) {
+    descs <- activeWorkPeriods
+    descs <- activeBreakPeriods
+}
+
+func(collector ActivityCollector) Collect(metrics chan<- prometheus.Metric) {
+    db := getPersistence()
+    if db == nil {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), time.Duration(HealthCheckTimeout) * time.Second)
+    defer cancel()
+    periods, breaks, err := db.countActivePeriods(ctx)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to count active periods: %v", err))
+        return
+    }
+    metrics <- prometheus.MustNewConstMetric(activeWorkPeriods, prometheus.GaugeValue, float64(periods))
+    metrics <- prometheus.MustNewConstMetric(activeBreakPeriods, prometheus.GaugeValue, float64(breaks))
+}
+
+// ###########################################################
+// # Define persistence functions used to collect metrics
+// ###########################################################
+
+// function used to count all currently active work and break periods
+func(db Persistence) countActivePeriods(ctx context.Context) (int64, int64, error) {
+    var (periods int64; breaks int64)
+    result := db.conn.QueryRow(ctx, `SELECT (SELECT COUNT(*) FROM work_periods WHERE finished_at IS NULL),
+        (SELECT COUNT(*) FROM break_periods WHERE finished_at IS NULL)`)
+    if err := result.Scan(&periods, &breaks); err != nil {
+        return 0, 0, err
+    }
+    return periods, breaks, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "time"
+    "context"
+    "sync/atomic"
+    "github.com/gin-gonic/gin"
+)
+
+var (
+    // flag used to determine if service is ready to serve requests. the
+    // service is ready once connected to postgres and until shutdown starts
+    serviceReady int32
+
+    // routes that stream responses for longer than a regular request
+    // and are therefore never subject to the request timeout
+    untimedRoutes = map[string]bool{
+        "/go-timesheets/active/stream": true,
+        "/go-timesheets/admin/backup": true,
+        "/go-timesheets/admin/restore": true,
+    }
+)
+
+// function used to apply configured deadline to all requests. the deadline
+// is set on the request context, meaning that in-flight queries are
+// cancelled once the deadline is exceeded or the client disconnects
+func requestTimeout() gin.HandlerFunc {
+    return func(ctx *gin.Context) {
+        if RequestTimeout <= 0 || untimedRoutes[ctx.FullPath()] {
+            ctx.Next()
+            return
+        }
+        timeout, cancel := context.WithTimeout(ctx.Request.Context(), time.Duration(RequestTimeout) * time.Second)
+        defer cancel()
+        ctx.Request = ctx.Request.WithContext(timeout)
+        ctx.Next()
+    }
+}
+
+// function used to set readiness of the service
+func setReady(ready bool) {
+    var value int32
+    if ready {
+        value = 1
+    }
+    atomic.StoreInt32(&serviceReady, value)
+}
+
+// function used to determine if service is ready to serve requests
+func isReady() bool {
+    return atomic.LoadInt32(&serviceReady) == 1
+}
+
+// function used to reject requests while the service is not ready. routes
+// that do not depend on the persistence layer can be exempted
+func requireReady(exempt ...string) gin.HandlerFunc {
+    exempted := map[string]bool{}
+    for _, path := range(exempt) {
+        exempted[path] = true
+    }
+    return func(ctx *gin.Context) {
+        if !isReady() && !exempted[ctx.FullPath()] {
+            StandardHTTP.ServiceUnavailable(ctx)
+            return
+        }
+        ctx.Next()
+    }
+}
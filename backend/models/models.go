@@ -0,0 +1,296 @@
+package models
+
+import (
+    "time"
+    "encoding/json"
+    "github.com/google/uuid"
+)
+
+var (
+
+)
+
+type WorkPeriod struct {
+    PeriodId   uuid.UUID     `json:"periodId"`
+    CreatedAt  time.Time     `json:"createdAt"`
+    FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+    Breaks 	   []BreakPeriod `json:"breaks"`
+}
+
+func(period WorkPeriod) TotalHours() float64 {
+    finished := *(period.FinishedAt)
+    return finished.Sub(period.CreatedAt).Hours()
+}
+
+type ActiveWorkPeriod struct {
+    PeriodId    uuid.UUID          `json:"periodId"`
+    CreatedAt   time.Time          `json:"createdAt"`
+    ActiveSince float64            `json:"activeSince"`
+    ActiveBreak *ActiveBreakPeriod `json:"activeBreak"`
+}
+
+type BreakPeriod struct {
+    BreakId    uuid.UUID  `json:"breakId"`
+    CreatedAt  time.Time  `json:"createdAt"`
+    FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+func(period BreakPeriod) TotalHours() float64 {
+    finished := *(period.FinishedAt)
+    return finished.Sub(period.CreatedAt).Hours()
+}
+
+type ActiveBreakPeriod struct {
+    BreakId   uuid.UUID `json:"breakId"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserData struct {
+    Uid	        string		 `json:"uid"`
+    WorkPeriods []WorkPeriod `json:"workPeriods"`
+}
+
+type RangedAnalysisResults struct {
+    Start   time.Time       `json:"start"`
+    End     time.Time       `json:"end"`
+    Results AnalysisResults `json:"results"`
+}
+
+type AnalysisResults struct {
+    TotalPeriods    int     `json:"totalPeriods"`
+    TotalBreaks     int     `json:"totalBreaks"`
+    TotalWorkHours  float64 `json:"totalWorkHours"`
+    TotalBreakHours float64 `json:"totalBreakHours"`
+    NetWorkHours    float64 `json:"netWorkHours"`
+}
+
+type WorkPeriodAnalysisResults struct {
+    PeriodCount int    `json:"totalPeriods"`
+    TotalHours float64 `json:"totalHours"`
+}
+
+type BreakPeriodAnalysisResults struct {
+    BreakCount int     `json:"totalPeriods"`
+    TotalHours float64 `json:"totalHours"`
+}
+
+type BucketAnalysis struct {
+    TotalWorkHours  float64    `json:"totalWorkHours"`
+    TotalBreakHours float64    `json:"totalBreakHours"`
+    NetWorkHours    float64    `json:"netWorkHours"`
+    StartTime       *time.Time `json:"startTime,omitempty"`
+    EndTime         *time.Time `json:"endTime,omitempty"`
+    TotalPeriods    int        `json:"totalPeriods"`
+    TotalBreaks     int        `json:"totalBreaks"`
+}
+
+type BucketOverview struct {
+    BucketCount             int       `json:"bucketCount"`
+    TotalWorkHours          float64   `json:"totalWorkHours"`
+    TotalBreakHours         float64   `json:"totalBreakHours"`
+    AverageBucketWorkHours  float64   `json:"averageBucketWorkHours"`
+    AverageBucketBreakHours float64   `json:"averageBucketBreakHours"`
+    AveragePeriodLength     float64   `json:"averagePeriodLength"`
+    AverageBreakLength      float64   `json:"averageBreakLength"`
+    TotalPeriods            int       `json:"totalPeriods"`
+    TotalBreaks             int       `json:"totalBreaks"`
+}
+
+type TimesheetSubmission struct {
+    Uid         string     `json:"uid"`
+    WeekStart   time.Time  `json:"weekStart"`
+    Status      string     `json:"status"`
+    SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+    ReviewedBy  *string    `json:"reviewedBy,omitempty"`
+    ReviewedAt  *time.Time `json:"reviewedAt,omitempty"`
+    Comment     *string    `json:"comment,omitempty"`
+}
+
+type TimesheetReviewRequest struct {
+    Comment string `json:"comment"`
+}
+
+type Team struct {
+    TeamId    uuid.UUID    `json:"teamId"`
+    Name      string       `json:"name"`
+    CreatedAt time.Time    `json:"createdAt"`
+    Members   []TeamMember `json:"members,omitempty"`
+}
+
+type TeamMember struct {
+    Uid  string `json:"uid"`
+    Role string `json:"role"`
+}
+
+type TeamInvitation struct {
+    TeamId    uuid.UUID `json:"teamId"`
+    TeamName  string    `json:"teamName"`
+    Uid       string    `json:"uid"`
+    Role      string    `json:"role"`
+    InvitedBy string    `json:"invitedBy"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+type TeamRequest struct {
+    Name string `json:"name" binding:"required"`
+}
+
+type TeamMemberRequest struct {
+    Role string `json:"role" binding:"required"`
+}
+
+type TeamAnalysisResults struct {
+    Members   map[string]AnalysisResults `json:"members"`
+    Aggregate AnalysisResults            `json:"aggregate"`
+}
+
+type UserRolesRequest struct {
+    Roles []string `json:"roles" binding:"required"`
+}
+
+type APIToken struct {
+    TokenId    uuid.UUID  `json:"tokenId"`
+    Name       string     `json:"name"`
+    Scopes     []string   `json:"scopes"`
+    CreatedAt  time.Time  `json:"createdAt"`
+    LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+    RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+type APITokenRequest struct {
+    Name   string   `json:"name" binding:"required"`
+    Scopes []string `json:"scopes"`
+}
+
+type DailySummary struct {
+    Date       time.Time  `json:"date"`
+    Start      *time.Time `json:"start,omitempty"`
+    End        *time.Time `json:"end,omitempty"`
+    BreakHours float64    `json:"breakHours"`
+    NetHours   float64    `json:"netHours"`
+}
+
+type ImportError struct {
+    Row     int    `json:"row"`
+    Message string `json:"message"`
+}
+
+type ImportPreview struct {
+    DryRun       bool          `json:"dryRun"`
+    TotalPeriods int           `json:"totalPeriods"`
+    TotalBreaks  int           `json:"totalBreaks"`
+    Periods      []WorkPeriod  `json:"periods"`
+    Errors       []ImportError `json:"errors"`
+}
+
+type AuditEntry struct {
+    EntryId   uuid.UUID       `json:"entryId"`
+    Uid       string          `json:"uid"`
+    Actor     string          `json:"actor"`
+    Action    string          `json:"action"`
+    Details   json.RawMessage `json:"details"`
+    CreatedAt time.Time       `json:"createdAt"`
+}
+
+type AccountArchive struct {
+    Uid          string                `json:"uid"`
+    ExportedAt   time.Time             `json:"exportedAt"`
+    WorkPeriods  []WorkPeriod          `json:"workPeriods"`
+    Timesheets   []TimesheetSubmission `json:"timesheets"`
+    Teams        []Team                `json:"teams"`
+    Roles        []string              `json:"roles"`
+    APITokens    []APIToken            `json:"apiTokens"`
+    AuditEntries []AuditEntry          `json:"auditEntries"`
+}
+
+type ErasureSummary struct {
+    Uid          string `json:"uid"`
+    Mode         string `json:"mode"`
+    WorkPeriods  int64  `json:"workPeriods"`
+    BreakPeriods int64  `json:"breakPeriods"`
+    Timesheets   int64  `json:"timesheets"`
+}
+
+type BackupRecord struct {
+    Type       string          `json:"type"`
+    Version    int             `json:"version,omitempty"`
+    PeriodId   *uuid.UUID      `json:"periodId,omitempty"`
+    BreakId    *uuid.UUID      `json:"breakId,omitempty"`
+    Uid        string          `json:"uid,omitempty"`
+    CreatedAt  time.Time       `json:"createdAt"`
+    FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+    Counts     *RestoreSummary `json:"counts,omitempty"`
+}
+
+type RestoreSummary struct {
+    WorkPeriods  int `json:"workPeriods"`
+    BreakPeriods int `json:"breakPeriods"`
+}
+
+type Webhook struct {
+    WebhookId uuid.UUID `json:"webhookId"`
+    Uid       string    `json:"uid"`
+    Url       string    `json:"url"`
+    Secret    string    `json:"-"`
+    Events    []string  `json:"events"`
+    Global    bool      `json:"global"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookRequest struct {
+    Url    string   `json:"url" binding:"required"`
+    Events []string `json:"events" binding:"required,min=1"`
+    Global bool     `json:"global"`
+}
+
+type PeriodEvent struct {
+    EventId    int64           `json:"eventId"`
+    Event      string          `json:"event"`
+    Uid        string          `json:"uid"`
+    PeriodId   uuid.UUID       `json:"periodId"`
+    BreakId    *uuid.UUID      `json:"breakId,omitempty"`
+    OccurredAt time.Time       `json:"occurredAt"`
+    Data       json.RawMessage `json:"data"`
+}
+
+type PeriodEventPage struct {
+    Events  []PeriodEvent `json:"events"`
+    Cursor  int64         `json:"cursor"`
+    HasMore bool          `json:"hasMore"`
+}
+
+type WebhookDelivery struct {
+    DeliveryId    uuid.UUID       `json:"deliveryId"`
+    Event         string          `json:"event"`
+    Payload       json.RawMessage `json:"payload"`
+    Status        string          `json:"status"`
+    Attempts      int             `json:"attempts"`
+    NextAttemptAt time.Time       `json:"nextAttemptAt"`
+    LastAttemptAt *time.Time      `json:"lastAttemptAt"`
+    ResponseCode  *int            `json:"responseCode"`
+    LastError     *string         `json:"lastError"`
+    CreatedAt     time.Time       `json:"createdAt"`
+}
+
+type ClaimedWebhookDelivery struct {
+    Delivery WebhookDelivery
+    Webhook  Webhook
+}
+
+type DependencyStatus struct {
+    Status  string  `json:"status"`
+    Latency float64 `json:"latencyMs"`
+    Error   string  `json:"error,omitempty"`
+}
+
+type ReadinessReport struct {
+    Status       string                      `json:"status"`
+    Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+type ConfigurationEntry struct {
+    Key    string `json:"key"`
+    Value  string `json:"value"`
+    Source string `json:"source"`
+    Secret bool   `json:"secret"`
+}
@@ -3,254 +3,540 @@ package main
 import (
     "fmt"
     "time"
+    "sync"
+    "errors"
     "context"
+    "github.com/PSauerborn/go-timesheets/models"
     "github.com/jackc/pgx/v4"
     "github.com/jackc/pgx/v4/pgxpool"
     "github.com/google/uuid"
     log "github.com/sirupsen/logrus"
 )
 
-var persistence *Persistence
+var (
+    // persistence layer used by all handlers. the persistence layer is
+    // connected in the background while health checks and metrics are
+    // already being served, and is therefore published under a lock once
+    // the schema has been initialized. handlers read the variable directly
+    // since they are only invoked once the service has been marked as ready
+    persistence *Persistence
+    persistenceMutex sync.RWMutex
+
+    ErrActivePeriodExists = errors.New("work period is already active")
+    ErrActiveBreakExists = errors.New("break period is already active")
+    ErrPeriodClosed = errors.New("work period is already closed")
+    ErrBreakClosed = errors.New("break period is already closed")
+)
 
 type Persistence struct {
     conn *pgxpool.Pool
 }
 
-// function used to connect postgres connection
-func ConnectPersistence() {
-    log.Info(fmt.Sprintf("attempting postgres connection with connection string %s", PostgresConnection))
-    db, err := pgxpool.Connect(context.Background(), PostgresConnection)
-    if err != nil {
-        log.Fatal(fmt.Errorf("unable to connect to postgres server: %v", err))
+// function used to connect postgres connection. connections are retried
+// with exponential backoff until the connection succeeds, the configured
+// number of attempts is exhausted or the given context is cancelled
+func ConnectPersistence(ctx context.Context) error {
+    delay := time.Duration(PostgresRetryInterval) * time.Second
+    for attempt := 1; ; attempt++ {
+        logger(ctx).Info(fmt.Sprintf("attempting postgres connection with connection string %s", redactConnectionString(PostgresConnection)))
+        db, err := pgxpool.Connect(ctx, PostgresConnection)
+        if err == nil {
+            logger(ctx).Info("successfully connected to postgres")
+            connected := &Persistence{db}
+            if InitializeSchema {
+                if err := connected.initializeSchema(ctx); err != nil {
+                    db.Close()
+                    return fmt.Errorf("unable to initialize postgres schema: %v", err)
+                }
+            }
+            // publish persistence layer once fully initialized
+            persistenceMutex.Lock()
+            persistence = connected
+            persistenceMutex.Unlock()
+            return nil
+        }
+        if PostgresConnectAttempts > 0 && attempt >= PostgresConnectAttempts {
+            return fmt.Errorf("unable to connect to postgres server after %d attempts: %v", attempt, err)
+        }
+        logger(ctx).Warn(fmt.Sprintf("unable to connect to postgres server (attempt %d), retrying in %s: %v", attempt, delay, err))
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(delay):
+        }
+        delay *= 2
+        if max := time.Duration(PostgresRetryMaxInterval) * time.Second; delay > max {
+            delay = max
+        }
+    }
+}
+
+// function used to retrieve persistence layer from outside of request
+// handlers. nil is returned if the persistence layer is not yet connected
+func getPersistence() *Persistence {
+    persistenceMutex.RLock()
+    defer persistenceMutex.RUnlock()
+    return persistence
+}
+
+// function used to close all connections held by the persistence layer
+func ClosePersistence() {
+    if db := getPersistence(); db != nil {
+        log.Info("closing postgres connection pool")
+        db.conn.Close()
+    }
+}
+
+// function used to initialize postgres schema by executing
+// all schema statements in order
+func(db Persistence) initializeSchema(ctx context.Context) error {
+    logger(ctx).Debug("initializing postgres schema")
+    for _, statement := range(schemaStatements) {
+        _, err := db.conn.Exec(ctx, statement)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to execute schema statement: %v", err))
+            return err
+        }
     }
-    log.Info("successfully connected to postgres")
-    // connect persistence and assign to persistence var
-    persistence = &Persistence{db}
+    logger(ctx).Info("successfully initialized postgres schema")
+    return nil
 }
 
-// function used to create new work period in postgres datebase
-func(db Persistence) createWorkPeriod(uid string) (ActiveWorkPeriod, error) {
-    log.Debug(fmt.Sprintf("creating new work period for user %s", uid))
+// function used to create new work period in postgres datebase. the
+// work period and the corresponding event are written in a single transaction,
+// and ErrActivePeriodExists is returned if the user already has an active period
+func(db Persistence) createWorkPeriod(ctx context.Context, uid string) (models.ActiveWorkPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("creating new work period for user %s", uid))
     periodId := uuid.New()
     now := time.Now()
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return models.ActiveWorkPeriod{}, err
+    }
+    defer tx.Rollback(ctx)
+    // lock user to prevent concurrent requests from creating multiple active periods
+    if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", uid); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock user %s: %v", uid, err))
+        return models.ActiveWorkPeriod{}, err
+    }
+    if err := lockTimesheetWeek(ctx, tx, uid, weekStart(now)); err != nil {
+        return models.ActiveWorkPeriod{}, err
+    }
+    var active bool
+    if err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM work_periods WHERE uid=$1 AND finished_at IS NULL)", uid).Scan(&active); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve active period for user %s: %v", uid, err))
+        return models.ActiveWorkPeriod{}, err
+    }
+    if active {
+        return models.ActiveWorkPeriod{}, ErrActivePeriodExists
+    }
     // create new work period and parse into ActiveWorkPeriod struct
-    _, err := db.conn.Exec(context.Background(), "INSERT INTO work_periods(period_id, uid, created_at) VALUES($1,$2,$3)", periodId, uid, now)
+    _, err = tx.Exec(ctx, "INSERT INTO work_periods(period_id, uid, created_at) VALUES($1,$2,$3)", periodId, uid, now)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new work period: %v", err))
-        return ActiveWorkPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to create new work period: %v", err))
+        return models.ActiveWorkPeriod{}, err
+    }
+    period := models.WorkPeriod{PeriodId: periodId, CreatedAt: now, Breaks: []models.BreakPeriod{}}
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventWorkPeriodCreated, periodId, nil, period); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to record work period event: %v", err))
+        return models.ActiveWorkPeriod{}, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit work period: %v", err))
+        return models.ActiveWorkPeriod{}, err
     }
-    log.Info(fmt.Sprintf("successfully created new work period with ID %s", periodId))
-    return ActiveWorkPeriod{PeriodId: periodId, CreatedAt: now}, nil
+    logger(ctx).Info(fmt.Sprintf("successfully created new work period with ID %s", periodId))
+    return models.ActiveWorkPeriod{PeriodId: periodId, CreatedAt: now}, nil
 }
 
-// function used to create new break period in database
-func(db Persistence) createBreakPeriod(periodId uuid.UUID) (ActiveBreakPeriod, error) {
-    log.Debug(fmt.Sprintf("creating new break period for work period %s", periodId))
+// function used to create new break period in database. the break
+// period and the corresponding event are written in a single transaction
+// while holding a lock on the work period, meaning that breaks can only
+// be created for active work periods without an active break
+func(db Persistence) createBreakPeriod(ctx context.Context, periodId uuid.UUID) (models.ActiveBreakPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("creating new break period for work period %s", periodId))
     breakId := uuid.New()
     now := time.Now()
-    // create new break period and insert into database
-    _, err := db.conn.Exec(context.Background(), "INSERT INTO break_periods(break_id, period_id, created_at) VALUES($1,$2,$3)", breakId, periodId, now)
+    tx, err := db.conn.Begin(ctx)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new work period: %v", err))
-        return ActiveBreakPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return models.ActiveBreakPeriod{}, err
     }
-    log.Info(fmt.Sprintf("successfully created new break period %s", breakId))
-    return ActiveBreakPeriod{BreakId: breakId, CreatedAt: now}, nil
-}
+    defer tx.Rollback(ctx)
 
-// function used to retrieve user data. all work periods are
-// retrieved first, and the list of work periods is then used
-// to retrieve the list of break periods, which are all combined
-func(db Persistence) getUserData(uid string) (UserData, error) {
-    log.Debug(fmt.Sprintf("fetching data for user %s", uid))
-    // retrieve all periods from database for user
-    rows, err := db.conn.Query(context.Background(), "SELECT period_id FROM work_periods WHERE uid=$1 AND finished_at IS NOT NULL", uid)
+    uid, finishedAt, err := lockWorkPeriod(ctx, tx, periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve work periods for user %s: %v", uid, err))
-        switch err {
-        case pgx.ErrNoRows:
-            return UserData{ Uid: uid, WorkPeriods: []WorkPeriod{}}, nil
-        default:
-            return UserData{}, err
-        }
+        return models.ActiveBreakPeriod{}, err
+    }
+    if finishedAt != nil {
+        return models.ActiveBreakPeriod{}, ErrPeriodClosed
+    }
+    var active bool
+    if err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM break_periods WHERE period_id=$1 AND finished_at IS NULL)", periodId).Scan(&active); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve active break period for work period %s: %v", periodId, err))
+        return models.ActiveBreakPeriod{}, err
+    }
+    if active {
+        return models.ActiveBreakPeriod{}, ErrActiveBreakExists
+    }
+    // create new break period and insert into database
+    _, err = tx.Exec(ctx, "INSERT INTO break_periods(break_id, period_id, created_at) VALUES($1,$2,$3)", breakId, periodId, now)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to create new work period: %v", err))
+        return models.ActiveBreakPeriod{}, err
+    }
+    period := models.BreakPeriod{BreakId: breakId, CreatedAt: now}
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventBreakPeriodCreated, periodId, &breakId, period); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to record break period event: %v", err))
+        return models.ActiveBreakPeriod{}, err
     }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit break period: %v", err))
+        return models.ActiveBreakPeriod{}, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully created new break period %s", breakId))
+    return models.ActiveBreakPeriod{BreakId: breakId, CreatedAt: now}, nil
+}
 
-    periods := []WorkPeriod{}
-    // iterate over period ID's and retrieve full period
+// function used to scan list of period or break IDs from query results.
+// rows are closed before returning so that the connection is released
+// before the individual periods are retrieved
+func scanPeriodIds(rows pgx.Rows) ([]uuid.UUID, error) {
+    defer rows.Close()
+    ids := []uuid.UUID{}
     for rows.Next() {
-        var periodId uuid.UUID
-        err := rows.Scan(&periodId)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to process work period: %v", err))
-            continue
+        var id uuid.UUID
+        if err := rows.Scan(&id); err != nil {
+            return ids, err
         }
+        ids = append(ids, id)
+    }
+    return ids, rows.Err()
+}
+
+// function used to retrieve work periods with the given IDs along
+// with all break periods
+func(db Persistence) getWorkPeriods(ctx context.Context, periodIds []uuid.UUID) ([]models.WorkPeriod, error) {
+    periods := []models.WorkPeriod{}
+    for _, periodId := range(periodIds) {
         // retrieve period and all breaks from database
-        period, err := db.getWorkPeriod(periodId)
+        period, err := db.getWorkPeriod(ctx, periodId)
         if err != nil {
-            log.Error(fmt.Errorf("unable to retrieve work period %s", periodId))
-        } else {
-            periods = append(periods, period)
+            logger(ctx).Error(fmt.Errorf("unable to retrieve work period %s: %v", periodId, err))
+            return periods, err
         }
+        periods = append(periods, period)
+    }
+    return periods, nil
+}
+
+// function used to retrieve user data. all work periods are
+// retrieved first, and the list of work periods is then used
+// to retrieve the list of break periods, which are all combined
+func(db Persistence) getUserData(ctx context.Context, uid string) (models.UserData, error) {
+    logger(ctx).Debug(fmt.Sprintf("fetching data for user %s", uid))
+    // retrieve all periods from database for user
+    rows, err := db.conn.Query(ctx, "SELECT period_id FROM work_periods WHERE uid=$1 AND finished_at IS NOT NULL", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve work periods for user %s: %v", uid, err))
+        return models.UserData{}, err
+    }
+    periodIds, err := scanPeriodIds(rows)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to process work periods for user %s: %v", uid, err))
+        return models.UserData{}, err
     }
-    return UserData{Uid: uid, WorkPeriods: periods}, nil
+    periods, err := db.getWorkPeriods(ctx, periodIds)
+    if err != nil {
+        return models.UserData{}, err
+    }
+    return models.UserData{Uid: uid, WorkPeriods: periods}, nil
 }
 
 // function used to retrieve user data within a specific time range.
-// note that this is the equivalent of db.getUserData() with the
+// note that this is the equivalent of getUserData() with the
 // additional timestamp constraint
-func(db Persistence) getUserDataOverRange(uid string, start, end time.Time) (UserData, error) {
-    log.Debug(fmt.Sprintf("fetching data for user %s", uid))
+func(db Persistence) getUserDataOverRange(ctx context.Context, uid string, start, end time.Time) (models.UserData, error) {
+    logger(ctx).Debug(fmt.Sprintf("fetching data for user %s", uid))
     // retrieve all periods from database what are completed
-    rows, err := db.conn.Query(context.Background(), "SELECT period_id FROM work_periods WHERE uid=$1 AND created_at > $2 AND created_at < $3 AND finished_at IS NOT NULL", uid, start, end)
+    rows, err := db.conn.Query(ctx, "SELECT period_id FROM work_periods WHERE uid=$1 AND created_at > $2 AND created_at < $3 AND finished_at IS NOT NULL ORDER BY created_at", uid, start, end)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve work periods for user %s: %v", uid, err))
-        switch err {
-        case pgx.ErrNoRows:
-            return UserData{ Uid: uid, WorkPeriods: []WorkPeriod{}}, nil
-        default:
-            return UserData{}, err
-        }
+        logger(ctx).Error(fmt.Errorf("unable to retrieve work periods for user %s: %v", uid, err))
+        return models.UserData{}, err
     }
-
-    periods := []WorkPeriod{}
-    // iterate over period ID's and retrieve full period
-    for rows.Next() {
-        var periodId uuid.UUID
-        err := rows.Scan(&periodId)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to process work period: %v", err))
-            continue
-        }
-
-        // retrieve period and all breaks from database
-        period, err := db.getWorkPeriod(periodId)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to retrieve work period %s", periodId))
-        } else {
-            periods = append(periods, period)
-        }
+    periodIds, err := scanPeriodIds(rows)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to process work periods for user %s: %v", uid, err))
+        return models.UserData{}, err
+    }
+    periods, err := db.getWorkPeriods(ctx, periodIds)
+    if err != nil {
+        return models.UserData{}, err
     }
-    return UserData{Uid: uid, WorkPeriods: periods}, nil
+    return models.UserData{Uid: uid, WorkPeriods: periods}, nil
 }
 
 // function used to get a specific break period from the database
-func(db Persistence) getBreakPeriod(breakId uuid.UUID) (BreakPeriod, error) {
-    log.Debug(fmt.Sprintf("retrieving break period %s", breakId))
+func(db Persistence) getBreakPeriod(ctx context.Context, breakId uuid.UUID) (models.BreakPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving break period %s", breakId))
 
     var (periodId uuid.UUID; createdAt time.Time; finishedAt *time.Time)
     // execute postgres query to get break from database
-    breakPeriod := db.conn.QueryRow(context.Background(), "SELECT period_id,created_at,finished_at FROM break_periods WHERE break_id=$1", breakId)
+    breakPeriod := db.conn.QueryRow(ctx, "SELECT period_id,created_at,finished_at FROM break_periods WHERE break_id=$1", breakId)
     err := breakPeriod.Scan(&periodId, &createdAt, &finishedAt)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve break period %s: %v", breakId, err))
-        return BreakPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break period %s: %v", breakId, err))
+        return models.BreakPeriod{}, err
     }
-    return BreakPeriod{BreakId: breakId, CreatedAt: createdAt, FinishedAt: finishedAt}, nil
+    return models.BreakPeriod{BreakId: breakId, CreatedAt: createdAt, FinishedAt: finishedAt}, nil
 }
 
 // function used to retrieve all break periods associated with a particular
 // work period
-func(db Persistence) getBreakPeriods(periodId uuid.UUID) ([]BreakPeriod, error) {
-    log.Debug(fmt.Sprintf("retrieving break periods for work period %s", periodId))
-    breaks := []BreakPeriod{}
+func(db Persistence) getBreakPeriods(ctx context.Context, periodId uuid.UUID) ([]models.BreakPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving break periods for work period %s", periodId))
+    breaks := []models.BreakPeriod{}
     // get all break ID's associated with period ID
-    rows, err := db.conn.Query(context.Background(), "SELECT break_id FROM break_periods WHERE period_id=$1", periodId)
+    rows, err := db.conn.Query(ctx, "SELECT break_id FROM break_periods WHERE period_id=$1", periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve break periods for period ID %s: %v", periodId, err))
-        switch err {
-        case pgx.ErrNoRows:
-            return breaks, nil
-        default:
-            return breaks, err
-        }
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break periods for period ID %s: %v", periodId, err))
+        return breaks, err
     }
 
-    for rows.Next() {
-        var breakId uuid.UUID
-        err := rows.Scan(&breakId)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to parse breakId: %v", err))
-            continue
-        }
+    breakIds, err := scanPeriodIds(rows)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to process break periods for period ID %s: %v", periodId, err))
+        return breaks, err
+    }
+    for _, breakId := range(breakIds) {
         // retrieve break details from database using break ID
-        breakPeriod, err := db.getBreakPeriod(breakId)
+        breakPeriod, err := db.getBreakPeriod(ctx, breakId)
         if err != nil {
-            log.Error(fmt.Errorf("unable to retrieve break %s: %v", breakId, err))
-        } else {
-            breaks = append(breaks, breakPeriod)
+            logger(ctx).Error(fmt.Errorf("unable to retrieve break %s: %v", breakId, err))
+            return breaks, err
         }
+        breaks = append(breaks, breakPeriod)
     }
     return breaks, nil
 }
 
 // function used to retrieve work period from database given a work period ID
-func(db Persistence) getWorkPeriod(periodId uuid.UUID) (WorkPeriod, error) {
-    log.Debug(fmt.Sprintf("retrieving work period %s", periodId))
+func(db Persistence) getWorkPeriod(ctx context.Context, periodId uuid.UUID) (models.WorkPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving work period %s", periodId))
 
     var (createdAt time.Time; finishedAt *time.Time)
 
-    period := db.conn.QueryRow(context.Background(), "SELECT created_at,finished_at FROM work_periods WHERE period_id=$1", periodId)
+    period := db.conn.QueryRow(ctx, "SELECT created_at,finished_at FROM work_periods WHERE period_id=$1", periodId)
     err := period.Scan(&createdAt, &finishedAt)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve work period %s: %v", periodId, err))
-        return WorkPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to retrieve work period %s: %v", periodId, err))
+        return models.WorkPeriod{}, err
     }
     // get break periods for the work period from database
-    breaks, err := db.getBreakPeriods(periodId)
+    breaks, err := db.getBreakPeriods(ctx, periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to get break periods for work period %s: %v", periodId, err))
-        return WorkPeriod{}, nil
+        logger(ctx).Error(fmt.Errorf("unable to get break periods for work period %s: %v", periodId, err))
+        return models.WorkPeriod{}, err
     }
-    return WorkPeriod{PeriodId: periodId, CreatedAt: createdAt, FinishedAt: finishedAt, Breaks: breaks}, nil
+    return models.WorkPeriod{PeriodId: periodId, CreatedAt: createdAt, FinishedAt: finishedAt, Breaks: breaks}, nil
 }
 
 // function used to close work period given work period ID
-func(db Persistence) closeWorkPeriod(periodId uuid.UUID) error {
-    log.Debug(fmt.Sprintf("closing work period %s", periodId))
-    _, err := db.conn.Exec(context.Background(), "UPDATE work_periods SET finished_at=$1 WHERE period_id=$2", time.Now(), periodId)
+func(db Persistence) closeWorkPeriod(ctx context.Context, periodId uuid.UUID) error {
+    return db.closeWorkPeriodAt(ctx, periodId, time.Now())
+}
+
+// function used to close work period at a given time. the work period is
+// locked for the duration of the transaction, and any break that is still
+// active is closed along with the work period. when closing periods in the
+// past, breaks started after the given time are deleted and breaks ending
+// after the given time are clamped to it. all updates and the
+// corresponding events are written in a single transaction
+func(db Persistence) closeWorkPeriodAt(ctx context.Context, periodId uuid.UUID, now time.Time) error {
+    logger(ctx).Debug(fmt.Sprintf("closing work period %s", periodId))
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    uid, finishedAt, err := lockWorkPeriod(ctx, tx, periodId)
+    if err != nil {
+        return err
+    }
+    if finishedAt != nil {
+        return ErrPeriodClosed
+    }
+    // remove breaks started after the end of the work period and close or
+    // clamp remaining breaks before closing the work period itself
+    changes := []struct { event string; query string }{
+        {EventBreakPeriodDeleted, `DELETE FROM break_periods WHERE period_id=$2 AND created_at > $1
+            RETURNING break_id,created_at,finished_at,false`},
+        {EventBreakPeriodClosed, `UPDATE break_periods b SET finished_at=$1 FROM (
+                SELECT break_id,finished_at FROM break_periods WHERE period_id=$2 AND (finished_at IS NULL OR finished_at > $1) FOR UPDATE) previous
+            WHERE b.break_id=previous.break_id RETURNING b.break_id,b.created_at,b.finished_at,previous.finished_at IS NOT NULL`},
+    }
+    for _, change := range(changes) {
+        rows, err := tx.Query(ctx, change.query, now, periodId)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to close break periods for work period %s: %v", periodId, err))
+            return err
+        }
+        events, breaks := []string{}, []models.BreakPeriod{}
+        for rows.Next() {
+            var (breakPeriod models.BreakPeriod; clamped bool)
+            if err := rows.Scan(&breakPeriod.BreakId, &breakPeriod.CreatedAt, &breakPeriod.FinishedAt, &clamped); err != nil {
+                rows.Close()
+                logger(ctx).Error(fmt.Errorf("unable to process break period: %v", err))
+                return err
+            }
+            // breaks that were already closed are updated rather than closed
+            event := change.event
+            if clamped {
+                event = EventBreakPeriodUpdated
+            }
+            events, breaks = append(events, event), append(breaks, breakPeriod)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+        for index, breakPeriod := range(breaks) {
+            breakId := breakPeriod.BreakId
+            if _, err := recordPeriodEvent(ctx, tx, uid, events[index], periodId, &breakId, breakPeriod); err != nil {
+                logger(ctx).Error(fmt.Errorf("unable to record break period event: %v", err))
+                return err
+            }
+        }
+    }
+
+    period := models.WorkPeriod{PeriodId: periodId, Breaks: []models.BreakPeriod{}}
+    result := tx.QueryRow(ctx, "UPDATE work_periods SET finished_at=$1 WHERE period_id=$2 RETURNING created_at,finished_at", now, periodId)
+    if err := result.Scan(&period.CreatedAt, &period.FinishedAt); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to close work period %s: %v", periodId, err))
+        return err
+    }
+    rows, err := tx.Query(ctx, "SELECT break_id,created_at,finished_at FROM break_periods WHERE period_id=$1 ORDER BY created_at", periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to close work period %s: %v", periodId, err))
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break periods for work period %s: %v", periodId, err))
         return err
     }
-    log.Info(fmt.Sprintf("successfully updated work period %s", periodId))
+    for rows.Next() {
+        var breakPeriod models.BreakPeriod
+        if err := rows.Scan(&breakPeriod.BreakId, &breakPeriod.CreatedAt, &breakPeriod.FinishedAt); err != nil {
+            rows.Close()
+            logger(ctx).Error(fmt.Errorf("unable to process break period: %v", err))
+            return err
+        }
+        period.Breaks = append(period.Breaks, breakPeriod)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventWorkPeriodClosed, periodId, nil, period); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to record work period event: %v", err))
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit work period %s: %v", periodId, err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully updated work period %s", periodId))
     return nil
 }
 
-// function used to close break period given particular break period ID
-func(db Persistence) closeBreakPeriod(breakId uuid.UUID) error {
-    log.Debug(fmt.Sprintf("closing work break %s", breakId))
-    _, err := db.conn.Exec(context.Background(), "UPDATE break_periods SET finished_at=$1 WHERE break_id=$2", time.Now(), breakId)
+// function used to close break period given particular break period ID.
+// the break period is locked for the duration of the transaction, and the
+// update and the corresponding event are written in a single transaction
+func(db Persistence) closeBreakPeriod(ctx context.Context, breakId uuid.UUID) error {
+    logger(ctx).Debug(fmt.Sprintf("closing work break %s", breakId))
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    var (periodId uuid.UUID; finishedAt *time.Time)
+    if err := tx.QueryRow(ctx, "SELECT period_id FROM break_periods WHERE break_id=$1", breakId).Scan(&periodId); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve break period %s: %v", breakId, err))
+        return err
+    }
+    // work periods are always locked before break periods to avoid deadlocks
+    uid, _, err := lockWorkPeriod(ctx, tx, periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to close work period %s: %v", breakId, err))
         return err
     }
-    log.Info(fmt.Sprintf("successfully updated work period %s", breakId))
+    if err := tx.QueryRow(ctx, "SELECT finished_at FROM break_periods WHERE break_id=$1 FOR UPDATE", breakId).Scan(&finishedAt); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock break period %s: %v", breakId, err))
+        return err
+    }
+    if finishedAt != nil {
+        return ErrBreakClosed
+    }
+    period := models.BreakPeriod{BreakId: breakId}
+    result := tx.QueryRow(ctx, "UPDATE break_periods SET finished_at=$1 WHERE break_id=$2 RETURNING created_at,finished_at", time.Now(), breakId)
+    if err := result.Scan(&period.CreatedAt, &period.FinishedAt); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to close work period %s: %v", breakId, err))
+        return err
+    }
+    if _, err := recordPeriodEvent(ctx, tx, uid, EventBreakPeriodClosed, periodId, &breakId, period); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to record break period event: %v", err))
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit break period %s: %v", breakId, err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully updated work period %s", breakId))
     return nil
 }
 
+// function used to lock work period for the duration of the given
+// transaction. the owner and finish timestamp of the period are returned.
+// ErrWeekLocked is returned if the period belongs to an approved week,
+// which is checked within the same transaction so that approvals cannot
+// be granted between the check and the update
+func lockWorkPeriod(ctx context.Context, tx pgx.Tx, periodId uuid.UUID) (string, *time.Time, error) {
+    var (uid string; createdAt time.Time; finishedAt *time.Time)
+    result := tx.QueryRow(ctx, "SELECT uid,created_at,finished_at FROM work_periods WHERE period_id=$1 FOR UPDATE", periodId)
+    if err := result.Scan(&uid, &createdAt, &finishedAt); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to lock work period %s: %v", periodId, err))
+        return "", nil, err
+    }
+    if err := lockTimesheetWeek(ctx, tx, uid, weekStart(createdAt)); err != nil {
+        return "", nil, err
+    }
+    return uid, finishedAt, nil
+}
+
 // function used to retrieve current break period from database.
 // this is done by getting all work periods, ordering by timstamp
 // and selecting the latest entry. Note that only non-completed
 // periods are selected
-func(db Persistence) getActivePeriod(uid string) (ActiveWorkPeriod, error) {
-    log.Debug(fmt.Sprintf("retrieving active work period for user %s", uid))
+func(db Persistence) getActivePeriod(ctx context.Context, uid string) (models.ActiveWorkPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving active work period for user %s", uid))
 
     var (periodId uuid.UUID; createdAt time.Time)
-    period := db.conn.QueryRow(context.Background(), "SELECT period_id, created_at FROM work_periods WHERE uid=$1 AND finished_at IS NULL ORDER BY created_at DESC LIMIT 1", uid)
+    period := db.conn.QueryRow(ctx, "SELECT period_id, created_at FROM work_periods WHERE uid=$1 AND finished_at IS NULL ORDER BY created_at DESC LIMIT 1", uid)
     err := period.Scan(&periodId, &createdAt)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve active user period for user %s", uid))
-        return ActiveWorkPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to retrieve active user period for user %s", uid))
+        return models.ActiveWorkPeriod{}, err
     }
     // retrieve active work period from database
-    activeBreak, err := db.getActiveBreakPeriod(periodId)
+    activeBreak, err := db.getActiveBreakPeriod(ctx, periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve active break period: %v", err))
-        return ActiveWorkPeriod{}, err
+        logger(ctx).Error(fmt.Errorf("unable to retrieve active break period: %v", err))
+        return models.ActiveWorkPeriod{}, err
     }
     // evaluate time that period has been active for given current date and created
     active := time.Now().Sub(createdAt)
-    workPeriod := ActiveWorkPeriod{
+    workPeriod := models.ActiveWorkPeriod{
         PeriodId: periodId,
         CreatedAt: createdAt,
         ActiveSince: active.Hours(),
@@ -259,20 +545,20 @@ func(db Persistence) getActivePeriod(uid string) (ActiveWorkPeriod, error) {
     return workPeriod, nil
 }
 
-func(db Persistence) getActiveBreakPeriod(periodId uuid.UUID) (*ActiveBreakPeriod, error) {
-    log.Debug(fmt.Sprintf("retrieving active break period for period ID %s", periodId))
+func(db Persistence) getActiveBreakPeriod(ctx context.Context, periodId uuid.UUID) (*models.ActiveBreakPeriod, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving active break period for period ID %s", periodId))
 
     var (breakId uuid.UUID; created time.Time)
-    result := db.conn.QueryRow(context.Background(), "SELECT break_id, created_at FROM break_periods WHERE period_id=$1 AND finished_at IS NULL ORDER BY created_at DESC LIMIT 1", periodId)
+    result := db.conn.QueryRow(ctx, "SELECT break_id, created_at FROM break_periods WHERE period_id=$1 AND finished_at IS NULL ORDER BY created_at DESC LIMIT 1", periodId)
     err := result.Scan(&breakId, &created)
     if err != nil {
         switch err {
         case pgx.ErrNoRows:
             return nil, nil
         default:
-            log.Error(fmt.Errorf("unable to retreive active break period"))
+            logger(ctx).Error(fmt.Errorf("unable to retreive active break period"))
             return nil, err
         }
     }
-    return &ActiveBreakPeriod{BreakId: breakId, CreatedAt: created}, nil
+    return &models.ActiveBreakPeriod{BreakId: breakId, CreatedAt: created}, nil
 }
\ No newline at end of file
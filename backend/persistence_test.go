@@ -0,0 +1,178 @@
+package main
+
+import (
+    "os"
+    "sync"
+    "time"
+    "context"
+    "testing"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4/pgxpool"
+)
+
+// function used to connect to the postgres database used for tests. tests
+// are skipped if TEST_POSTGRES_CONNECTION is not set. note that the schema
+// is created in the given database, and that all data written by tests is
+// scoped to randomly generated user IDs
+func setupTestPersistence(t *testing.T) context.Context {
+    connection := os.Getenv("TEST_POSTGRES_CONNECTION")
+    if len(connection) == 0 {
+        t.Skip("TEST_POSTGRES_CONNECTION is not set")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+    t.Cleanup(cancel)
+    pool, err := pgxpool.Connect(ctx, connection)
+    if err != nil {
+        t.Fatalf("unable to connect to postgres: %v", err)
+    }
+    db := &Persistence{pool}
+    if err := db.initializeSchema(ctx); err != nil {
+        pool.Close()
+        t.Fatalf("unable to initialize schema: %v", err)
+    }
+
+    previous, webhooks := persistence, WebhooksEnabled
+    persistenceMutex.Lock()
+    persistence, WebhooksEnabled = db, false
+    persistenceMutex.Unlock()
+    t.Cleanup(func() {
+        persistenceMutex.Lock()
+        persistence, WebhooksEnabled = previous, webhooks
+        persistenceMutex.Unlock()
+        pool.Close()
+    })
+    return ctx
+}
+
+// function used to generate user ID that is unique to a single test
+func newTestUser(t *testing.T) string {
+    uid := "test-" + uuid.New().String()
+    t.Cleanup(func() {
+        ctx := context.Background()
+        persistence.conn.Exec(ctx, "DELETE FROM period_events WHERE uid=$1", uid)
+        persistence.conn.Exec(ctx, "DELETE FROM break_periods WHERE period_id IN (SELECT period_id FROM work_periods WHERE uid=$1)", uid)
+        persistence.conn.Exec(ctx, "DELETE FROM work_periods WHERE uid=$1", uid)
+    })
+    return uid
+}
+
+// function used to run the given function concurrently. all goroutines
+// are released at the same time to maximise contention
+func runConcurrently(count int, run func(index int) error) []error {
+    errs := make([]error, count)
+    start, group := make(chan struct{}), sync.WaitGroup{}
+    for i := 0; i < count; i++ {
+        group.Add(1)
+        go func(index int) {
+            defer group.Done()
+            <-start
+            errs[index] = run(index)
+        }(i)
+    }
+    close(start)
+    group.Wait()
+    return errs
+}
+
+func TestCreateWorkPeriodConcurrently(t *testing.T) {
+    ctx := setupTestPersistence(t)
+    for iteration := 0; iteration < 10; iteration++ {
+        uid := newTestUser(t)
+        errs := runConcurrently(8, func(index int) error {
+            _, err := persistence.createWorkPeriod(ctx, uid)
+            return err
+        })
+
+        created := 0
+        for _, err := range(errs) {
+            switch err {
+            case nil:
+                created++
+            case ErrActivePeriodExists:
+            default:
+                t.Fatalf("unexpected error creating work period: %v", err)
+            }
+        }
+        if created != 1 {
+            t.Fatalf("expected exactly one work period to be created, got %d", created)
+        }
+        var open int
+        if err := persistence.conn.QueryRow(ctx, "SELECT COUNT(*) FROM work_periods WHERE uid=$1 AND finished_at IS NULL", uid).Scan(&open); err != nil {
+            t.Fatalf("unable to count open work periods: %v", err)
+        }
+        if open != 1 {
+            t.Fatalf("expected exactly one open work period, got %d", open)
+        }
+    }
+}
+
+func TestCloseWorkPeriodRacesCreateBreakPeriod(t *testing.T) {
+    ctx := setupTestPersistence(t)
+    for iteration := 0; iteration < 20; iteration++ {
+        uid := newTestUser(t)
+        period, err := persistence.createWorkPeriod(ctx, uid)
+        if err != nil {
+            t.Fatalf("unable to create work period: %v", err)
+        }
+        errs := runConcurrently(2, func(index int) error {
+            if index == 0 {
+                return persistence.closeWorkPeriod(ctx, period.PeriodId)
+            }
+            _, err := persistence.createBreakPeriod(ctx, period.PeriodId)
+            return err
+        })
+        if errs[0] != nil {
+            t.Fatalf("unable to close work period: %v", errs[0])
+        }
+        if errs[1] != nil && errs[1] != ErrPeriodClosed {
+            t.Fatalf("unexpected error creating break period: %v", errs[1])
+        }
+
+        var open int
+        err = persistence.conn.QueryRow(ctx, `SELECT COUNT(*) FROM break_periods b JOIN work_periods w ON b.period_id=w.period_id
+            WHERE w.period_id=$1 AND w.finished_at IS NOT NULL AND (b.finished_at IS NULL OR b.finished_at > w.finished_at)`, period.PeriodId).Scan(&open)
+        if err != nil {
+            t.Fatalf("unable to count open break periods: %v", err)
+        }
+        if open != 0 {
+            t.Fatalf("expected no open break periods on closed work period, got %d", open)
+        }
+    }
+}
+
+func TestCloseWorkPeriodTwice(t *testing.T) {
+    ctx := setupTestPersistence(t)
+    for iteration := 0; iteration < 10; iteration++ {
+        uid := newTestUser(t)
+        period, err := persistence.createWorkPeriod(ctx, uid)
+        if err != nil {
+            t.Fatalf("unable to create work period: %v", err)
+        }
+        errs := runConcurrently(2, func(index int) error {
+            return persistence.closeWorkPeriod(ctx, period.PeriodId)
+        })
+
+        closed, rejected := 0, 0
+        for _, err := range(errs) {
+            switch err {
+            case nil:
+                closed++
+            case ErrPeriodClosed:
+                rejected++
+            default:
+                t.Fatalf("unexpected error closing work period: %v", err)
+            }
+        }
+        if closed != 1 || rejected != 1 {
+            t.Fatalf("expected one success and one ErrPeriodClosed, got %d successes and %d rejections", closed, rejected)
+        }
+        var events int
+        err = persistence.conn.QueryRow(ctx, "SELECT COUNT(*) FROM period_events WHERE period_id=$1 AND event=$2", period.PeriodId, EventWorkPeriodClosed).Scan(&events)
+        if err != nil {
+            t.Fatalf("unable to count events: %v", err)
+        }
+        if events != 1 {
+            t.Fatalf("expected exactly one close event, got %d", events)
+        }
+    }
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/jung-kurt/gofpdf"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    reportColumnWidths = []float64{45, 30, 30, 35, 35}
+    reportColumns = []string{"Date", "Start", "End", "Breaks (h)", "Net Hours"}
+)
+
+// function used to summarise the work periods of a single day
+func summariseDay(ctx context.Context, date time.Time, periods []models.WorkPeriod) models.DailySummary {
+    summary := models.DailySummary{Date: date}
+    for _, period := range(periods) {
+        if summary.Start == nil || period.CreatedAt.Before(*summary.Start) {
+            start := period.CreatedAt
+            summary.Start = &start
+        }
+        if period.FinishedAt != nil && (summary.End == nil || period.FinishedAt.After(*summary.End)) {
+            end := *period.FinishedAt
+            summary.End = &end
+        }
+    }
+    results := analysePeriods(ctx, periods)
+    summary.BreakHours = results.TotalBreakHours
+    summary.NetHours = results.NetWorkHours
+    return summary
+}
+
+// function used to generate monthly timesheet report for a user. the report
+// contains a daily table with weekly subtotals followed by the analysis
+// results for the month and space for signatures. data is retrieved in
+// the same manner as the grouped /data/:start/:end route
+func generateTimesheetReport(ctx context.Context, uid string, month time.Time) (*gofpdf.Fpdf, error) {
+    logger(ctx).Info(fmt.Sprintf("generating timesheet report for user %s and month %s", uid, month.Format("2006-01")))
+    start, end := month, month.AddDate(0, 1, 0)
+    data, err := persistence.getUserDataOverRange(ctx, uid, start, end)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to get user data: %v", err))
+        return nil, err
+    }
+    days := groupPeriodsByDay(data.WorkPeriods, start, end)
+
+    pdf := gofpdf.New("P", "mm", "A4", "")
+    pdf.SetTitle(fmt.Sprintf("Timesheet %s %s", uid, month.Format("January 2006")), true)
+    pdf.AddPage()
+    // write report header
+    pdf.SetFont("Helvetica", "B", 16)
+    pdf.CellFormat(0, 10, fmt.Sprintf("Timesheet - %s", month.Format("January 2006")), "", 1, "L", false, 0, "")
+    pdf.SetFont("Helvetica", "", 10)
+    pdf.CellFormat(0, 6, fmt.Sprintf("Employee: %s", uid), "", 1, "L", false, 0, "")
+    pdf.CellFormat(0, 6, fmt.Sprintf("Period: %s - %s", start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02")), "", 1, "L", false, 0, "")
+    pdf.CellFormat(0, 6, fmt.Sprintf("Generated: %s", time.Now().UTC().Format("2006-01-02 15:04 MST")), "", 1, "L", false, 0, "")
+    pdf.Ln(4)
+
+    // write daily table with weekly subtotals
+    pdf.SetFont("Helvetica", "B", 10)
+    for i, column := range(reportColumns) {
+        pdf.CellFormat(reportColumnWidths[i], 7, column, "1", 0, "C", false, 0, "")
+    }
+    pdf.Ln(-1)
+    weekNet, weekBreaks := 0.0, 0.0
+    for date := start; date.Before(end); date = date.AddDate(0, 0, 1) {
+        summary := summariseDay(ctx, date, days[date.Format("2006-01-02")])
+        weekNet += summary.NetHours
+        weekBreaks += summary.BreakHours
+
+        pdf.SetFont("Helvetica", "", 10)
+        startTime, endTime := "-", "-"
+        if summary.Start != nil {
+            startTime = summary.Start.Format("15:04")
+        }
+        if summary.End != nil {
+            endTime = summary.End.Format("15:04")
+        }
+        row := []string{date.Format("Mon 2006-01-02"), startTime, endTime, fmt.Sprintf("%.2f", summary.BreakHours), fmt.Sprintf("%.2f", summary.NetHours)}
+        for i, value := range(row) {
+            pdf.CellFormat(reportColumnWidths[i], 6, value, "1", 0, "C", false, 0, "")
+        }
+        pdf.Ln(-1)
+        // write weekly subtotal at the end of each week and month
+        if date.Weekday() == time.Sunday || !date.AddDate(0, 0, 1).Before(end) {
+            _, week := date.ISOWeek()
+            pdf.SetFont("Helvetica", "B", 10)
+            pdf.CellFormat(reportColumnWidths[0] + reportColumnWidths[1] + reportColumnWidths[2], 6, fmt.Sprintf("Week %d subtotal", week), "1", 0, "R", false, 0, "")
+            pdf.CellFormat(reportColumnWidths[3], 6, fmt.Sprintf("%.2f", weekBreaks), "1", 0, "C", false, 0, "")
+            pdf.CellFormat(reportColumnWidths[4], 6, fmt.Sprintf("%.2f", weekNet), "1", 1, "C", false, 0, "")
+            weekNet, weekBreaks = 0.0, 0.0
+        }
+    }
+
+    // write analysis results for the month
+    results := analysePeriods(ctx, data.WorkPeriods)
+    pdf.Ln(6)
+    pdf.SetFont("Helvetica", "B", 12)
+    pdf.CellFormat(0, 8, "Summary", "", 1, "L", false, 0, "")
+    pdf.SetFont("Helvetica", "", 10)
+    summary := [][]string{
+        {"Total Periods", fmt.Sprintf("%d", results.TotalPeriods)},
+        {"Total Breaks", fmt.Sprintf("%d", results.TotalBreaks)},
+        {"Total Work Hours", fmt.Sprintf("%.2f", results.TotalWorkHours)},
+        {"Total Break Hours", fmt.Sprintf("%.2f", results.TotalBreakHours)},
+        {"Net Work Hours", fmt.Sprintf("%.2f", results.NetWorkHours)},
+    }
+    for _, row := range(summary) {
+        pdf.CellFormat(60, 6, row[0], "1", 0, "L", false, 0, "")
+        pdf.CellFormat(35, 6, row[1], "1", 1, "C", false, 0, "")
+    }
+
+    // write signature lines for employee and manager
+    pdf.Ln(20)
+    x, y := pdf.GetXY()
+    pdf.Line(x, y, x + 70, y)
+    pdf.Line(x + 100, y, x + 170, y)
+    pdf.CellFormat(100, 6, "Employee signature / date", "", 0, "L", false, 0, "")
+    pdf.CellFormat(70, 6, "Manager signature / date", "", 1, "L", false, 0, "")
+    return pdf, pdf.Error()
+}
+
+// function used to write timesheet report for a given user and month
+func writeTimesheetReport(ctx *gin.Context, uid string) {
+    month, err := time.Parse("2006-01", ctx.Param("month"))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse month '%s': %v", ctx.Param("month"), err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid month")
+        return
+    }
+    pdf, err := generateTimesheetReport(ctx.Request.Context(), uid, month)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to generate timesheet report for user %s: %v", uid, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.Header("Content-Type", "application/pdf")
+    ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=timesheet_%s_%s.pdf", uid, month.Format("2006-01")))
+    ctx.Status(200)
+    if err := pdf.Output(ctx.Writer); err != nil {
+        log.Error(fmt.Errorf("unable to write timesheet report: %v", err))
+    }
+}
+
+// function used to generate monthly timesheet report for current user
+func getTimesheetReportHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to generate timesheet report for user %s", user))
+    writeTimesheetReport(ctx, user)
+}
+
+// function used to generate monthly timesheet report for a user managed
+// by the current user. only managers of the user can generate reports
+func getUserTimesheetReportHandler(ctx *gin.Context) {
+    member, ok := authorizeReviewer(ctx)
+    if !ok {
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to generate timesheet report for user %s", member))
+    writeTimesheetReport(ctx, member)
+}
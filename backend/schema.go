@@ -0,0 +1,104 @@
+package main
+
+// define list of statements used to initialize the postgres schema. all
+// statements are idempotent and are executed in order on startup, meaning
+// that new tables and indexes can simply be appended to the list
+var schemaStatements = []string{
+    `CREATE TABLE IF NOT EXISTS work_periods(
+        period_id UUID PRIMARY KEY,
+        uid TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        finished_at TIMESTAMP
+    )`,
+    `CREATE TABLE IF NOT EXISTS break_periods(
+        break_id UUID PRIMARY KEY,
+        period_id UUID NOT NULL REFERENCES work_periods(period_id),
+        created_at TIMESTAMP NOT NULL,
+        finished_at TIMESTAMP
+    )`,
+    `CREATE TABLE IF NOT EXISTS timesheet_submissions(
+        uid TEXT NOT NULL,
+        week_start DATE NOT NULL,
+        status TEXT NOT NULL,
+        submitted_at TIMESTAMP NOT NULL,
+        reviewed_by TEXT,
+        reviewed_at TIMESTAMP,
+        comment TEXT,
+        PRIMARY KEY(uid, week_start)
+    )`,
+    `CREATE TABLE IF NOT EXISTS teams(
+        team_id UUID PRIMARY KEY,
+        name TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    )`,
+    `CREATE TABLE IF NOT EXISTS team_members(
+        team_id UUID NOT NULL REFERENCES teams(team_id) ON DELETE CASCADE,
+        uid TEXT NOT NULL,
+        role TEXT NOT NULL,
+        PRIMARY KEY(team_id, uid)
+    )`,
+    `CREATE TABLE IF NOT EXISTS team_invitations(
+        team_id UUID NOT NULL REFERENCES teams(team_id) ON DELETE CASCADE,
+        uid TEXT NOT NULL,
+        role TEXT NOT NULL,
+        invited_by TEXT NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        PRIMARY KEY(team_id, uid)
+    )`,
+    `CREATE TABLE IF NOT EXISTS user_roles(
+        uid TEXT NOT NULL,
+        role TEXT NOT NULL,
+        PRIMARY KEY(uid, role)
+    )`,
+    `CREATE TABLE IF NOT EXISTS api_tokens(
+        token_id UUID PRIMARY KEY,
+        uid TEXT NOT NULL,
+        name TEXT NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        scopes TEXT[] NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        last_used_at TIMESTAMP,
+        revoked_at TIMESTAMP
+    )`,
+    `CREATE TABLE IF NOT EXISTS audit_log(
+        entry_id UUID PRIMARY KEY,
+        uid TEXT NOT NULL,
+        actor TEXT NOT NULL,
+        action TEXT NOT NULL,
+        details JSONB NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    )`,
+    `CREATE TABLE IF NOT EXISTS webhooks(
+        webhook_id UUID PRIMARY KEY,
+        uid TEXT NOT NULL,
+        url TEXT NOT NULL,
+        secret TEXT NOT NULL,
+        events TEXT[] NOT NULL,
+        global BOOLEAN NOT NULL DEFAULT FALSE,
+        created_at TIMESTAMP NOT NULL
+    )`,
+    `CREATE TABLE IF NOT EXISTS webhook_deliveries(
+        delivery_id UUID PRIMARY KEY,
+        webhook_id UUID NOT NULL REFERENCES webhooks(webhook_id) ON DELETE CASCADE,
+        event TEXT NOT NULL,
+        payload JSONB NOT NULL,
+        status TEXT NOT NULL,
+        attempts INTEGER NOT NULL,
+        next_attempt_at TIMESTAMP NOT NULL,
+        last_attempt_at TIMESTAMP,
+        response_code INTEGER,
+        last_error TEXT,
+        created_at TIMESTAMP NOT NULL
+    )`,
+    `CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status='pending'`,
+    `CREATE TABLE IF NOT EXISTS period_events(
+        event_id BIGSERIAL PRIMARY KEY,
+        uid TEXT NOT NULL,
+        event TEXT NOT NULL,
+        period_id UUID NOT NULL,
+        break_id UUID,
+        data JSONB NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    )`,
+    `CREATE INDEX IF NOT EXISTS period_events_uid ON period_events(uid, event_id)`,
+}
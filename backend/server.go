@@ -0,0 +1,134 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "strconv"
+    "net/http"
+    "crypto/tls"
+    log "github.com/sirupsen/logrus"
+)
+
+// function used to create HTTP server from configured address, timeouts and
+// limits. TLS is enabled if a certificate and key are configured
+func newServer(handler http.Handler) (*http.Server, error) {
+    server := &http.Server{
+        Addr: net.JoinHostPort(ListenAddress, strconv.Itoa(ListenPort)),
+        Handler: handler,
+        ReadTimeout: time.Duration(ServerReadTimeout) * time.Second,
+        ReadHeaderTimeout: time.Duration(ServerReadHeaderTimeout) * time.Second,
+        WriteTimeout: time.Duration(ServerWriteTimeout) * time.Second,
+        IdleTimeout: time.Duration(ServerIdleTimeout) * time.Second,
+        MaxHeaderBytes: ServerMaxHeaderBytes,
+    }
+    if isTLSEnabled() {
+        reloader, err := newCertificateReloader(TLSCertFile, TLSKeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("unable to load TLS certificate: %v", err)
+        }
+        server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.GetCertificate}
+    }
+    return server, nil
+}
+
+// function used to start serving requests using the given server. the
+// function blocks until the server is shut down
+func serve(server *http.Server) error {
+    if isTLSEnabled() {
+        log.Info(fmt.Sprintf("starting TLS server on %s", server.Addr))
+        // certificates are provided by the TLS config of the server
+        return server.ListenAndServeTLS("", "")
+    }
+    log.Info(fmt.Sprintf("starting server on %s", server.Addr))
+    return server.ListenAndServe()
+}
+
+// function used to determine if requests are served using TLS
+func isTLSEnabled() bool {
+    return len(TLSCertFile) > 0 && len(TLSKeyFile) > 0
+}
+
+// define struct used to serve TLS certificates. the certificate and key
+// files are checked for changes at most once per reload interval, and
+// the certificate is reloaded if either file has been modified. this
+// allows certificates to be renewed without restarting the service
+type CertificateReloader struct {
+    mutex       sync.RWMutex
+    certFile    string
+    keyFile     string
+    certificate *tls.Certificate
+    modified    time.Time
+    lastChecked time.Time
+}
+
+// function used to create new certificate reloader. the certificate is
+// loaded immediately so that invalid certificates are detected on startup
+func newCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+    reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+    modified, err := reloader.lastModified()
+    if err != nil {
+        return nil, err
+    }
+    if err := reloader.load(modified); err != nil {
+        return nil, err
+    }
+    return reloader, nil
+}
+
+// function used to load certificate and key from files
+func(reloader *CertificateReloader) load(modified time.Time) error {
+    certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+    if err != nil {
+        return err
+    }
+    reloader.mutex.Lock()
+    defer reloader.mutex.Unlock()
+    reloader.certificate, reloader.modified = &certificate, modified
+    log.Info(fmt.Sprintf("successfully loaded TLS certificate from %s", reloader.certFile))
+    return nil
+}
+
+// function used to retrieve latest modification time of certificate and key
+func(reloader *CertificateReloader) lastModified() (time.Time, error) {
+    var modified time.Time
+    for _, path := range([]string{reloader.certFile, reloader.keyFile}) {
+        info, err := os.Stat(path)
+        if err != nil {
+            return modified, err
+        }
+        if info.ModTime().After(modified) {
+            modified = info.ModTime()
+        }
+    }
+    return modified, nil
+}
+
+// function used to retrieve certificate for TLS handshakes. failures to
+// reload a modified certificate are logged, and the previous certificate
+// continues to be served until a valid certificate is available
+func(reloader *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    reloader.mutex.Lock()
+    stale := time.Since(reloader.lastChecked) > time.Duration(TLSReloadInterval) * time.Second
+    if stale {
+        reloader.lastChecked = time.Now()
+    }
+    current := reloader.modified
+    reloader.mutex.Unlock()
+
+    if stale {
+        modified, err := reloader.lastModified()
+        if err != nil {
+            log.Warn(fmt.Sprintf("unable to check TLS certificate for changes: %v", err))
+        } else if !modified.Equal(current) {
+            if err := reloader.load(modified); err != nil {
+                log.Error(fmt.Errorf("unable to reload TLS certificate: %v", err))
+            }
+        }
+    }
+    reloader.mutex.RLock()
+    defer reloader.mutex.RUnlock()
+    return reloader.certificate, nil
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+    "os"
     "fmt"
+    "sync"
     "time"
+    "context"
+    "syscall"
+    "net/http"
+    "os/signal"
     "strconv"
     "strings"
     "github.com/gin-gonic/gin"
     "github.com/google/uuid"
     "github.com/jackc/pgx/v4"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
     log "github.com/sirupsen/logrus"
     jaeger "github.com/PSauerborn/jaeger-negroni"
 )
@@ -23,54 +30,215 @@ func(metric UserIDMetric) MetricName() string {
 }
 
 func(metric UserIDMetric) EvaluateMetric(ctx *gin.Context) interface{} {
+    // tracing middleware runs before requests are rejected while the service
+    // is not ready, so requests cannot be authenticated at this point
+    if !isReady() {
+        return ""
+    }
     user := getUser(ctx)
     log.Debug(fmt.Sprintf("setting jaeger spans with user ID %s", user))
     return user
 }
 
-func main() {
-    // configure environment variables and connect persistence layer to database
-    ConfigureService()
-    ConnectPersistence()
+// function used to run the API server until a termination signal is
+// received. the service is configured using the given arguments
+func serveCommand(args []string) error {
+    // configure environment variables
+    ConfigureService(args)
 
-    // create new jaeger config and add uid metric
-    config := jaeger.Config("jaeger-agent", "go-timesheets-api", 6831)
+    // create tracer using configured exporter along with middleware
+    // config used to tag spans with the uid metric
+    tracer := setupTracing()
+    config := jaeger.Config(JaegerAgentHost, TracingServiceName, JaegerAgentPort)
     config.PreRequestMetrics = append(config.PreRequestMetrics, UserIDMetric{})
 
-    tracer := jaeger.NewTracer(config)
-    defer tracer.Close()
-
     router := gin.New()
-    router.Use(jaeger.JaegerNegroni(config))
+    router.Use(gin.Recovery())
+    router.Use(requestLogger())
+    if TracingExporter != TracingDisabled {
+        router.Use(jaeger.JaegerNegroni(config))
+    }
+    router.Use(instrumentRequests())
+    router.Use(requireReady("/metrics", "/go-timesheets/health", "/go-timesheets/health/live", "/go-timesheets/health/ready"))
+    router.Use(requestTimeout())
 
     // create handlers for user data routes
     router.GET("/go-timesheets/health", healthCheckHandler)
-    router.GET("/go-timesheets/active", getActivePeriodHandler)
-    router.GET("/go-timesheets/data", getUserDataHandler)
-    router.GET("/go-timesheets/data/:start/:end", getUserTimeRangeDataHandler)
+    router.GET("/go-timesheets/health/live", livenessHandler)
+    router.GET("/go-timesheets/health/ready", readinessHandler)
+    router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+    router.GET("/go-timesheets/active", authorize(RoleUser), getActivePeriodHandler)
+    router.GET("/go-timesheets/active/stream", authorize(RoleUser), streamActivePeriodHandler)
+    router.GET("/go-timesheets/events", authorize(RoleUser), listPeriodEventsHandler)
+    router.GET("/go-timesheets/data", authorize(RoleUser), getUserDataHandler)
+    router.GET("/go-timesheets/data/:start/:end", authorize(RoleUser), getUserTimeRangeDataHandler)
     // create handler to bucket and analyse values
-    router.GET("/go-timesheets/bucket_analysis/:start/:end", getUserBucketAnalysisHandler)
+    router.GET("/go-timesheets/bucket_analysis/:start/:end", authorize(RoleUser), getUserBucketAnalysisHandler)
     // create handlers for user data analysis routes
-    router.GET("/go-timesheets/analyse", getUserAnalysisHandler)
-    router.GET("/go-timesheets/analyse/:start/:end", getUserTimeRangeAnalysisHandler)
+    router.GET("/go-timesheets/analyse", authorize(RoleUser), getUserAnalysisHandler)
+    router.GET("/go-timesheets/analyse/:start/:end", authorize(RoleUser), getUserTimeRangeAnalysisHandler)
+    // create handlers to export user data
+    router.GET("/go-timesheets/export/:start/:end", authorize(RoleUser), exportUserDataHandler)
+    router.GET("/go-timesheets/reports/:month", authorize(RoleUser), getTimesheetReportHandler)
+    router.GET("/go-timesheets/reports/:month/users/:uid", authorize(RoleManager), getUserTimesheetReportHandler)
+    // create handlers to import historical data
+    router.POST("/go-timesheets/import", authorize(RoleUser), importUserDataHandler)
+    // create handlers for calendar feeds. feeds are authenticated by the token in the URL
+    router.POST("/go-timesheets/calendar", authorize(RoleUser), createCalendarFeedHandler)
+    router.GET("/go-timesheets/calendar/:token", getCalendarFeedHandler)
     // create handlers to create work and break periods
-    router.POST("/go-timesheets/work_period", createWorkPeriodHandler)
-    router.POST("/go-timesheets/break_period/:periodId", createBreakPeriodHandler)
+    router.POST("/go-timesheets/work_period", authorize(RoleUser), createWorkPeriodHandler)
+    router.POST("/go-timesheets/break_period/:periodId", authorize(RoleUser), createBreakPeriodHandler)
     // create handlers to end work and break periods
-    router.PATCH("/go-timesheets/work_period/:periodId", endWorkPeriodHandler)
-    router.PATCH("/go-timesheets/break_period/:breakId", endBreakPeriodHandler)
+    router.PATCH("/go-timesheets/work_period/:periodId", authorize(RoleUser), endWorkPeriodHandler)
+    router.PATCH("/go-timesheets/break_period/:breakId", authorize(RoleUser), endBreakPeriodHandler)
+    // create handlers to submit weekly timesheets for approval
+    router.GET("/go-timesheets/timesheets", authorize(RoleUser), listTimesheetsHandler)
+    router.GET("/go-timesheets/timesheets/:week", authorize(RoleUser), getTimesheetHandler)
+    router.POST("/go-timesheets/timesheets/:week/submit", authorize(RoleUser), submitTimesheetHandler)
+    // create handlers for managers to review timesheets
+    router.GET("/go-timesheets/approvals", authorize(RoleManager), listApprovalsHandler)
+    router.GET("/go-timesheets/approvals/:uid", authorize(RoleManager), listUserApprovalsHandler)
+    router.GET("/go-timesheets/approvals/:uid/:week", authorize(RoleManager), getApprovalHandler)
+    router.PATCH("/go-timesheets/approvals/:uid/:week/approve", authorize(RoleManager), approveTimesheetHandler)
+    router.PATCH("/go-timesheets/approvals/:uid/:week/reject", authorize(RoleManager), rejectTimesheetHandler)
+    // create handlers to manage teams and team members
+    router.GET("/go-timesheets/teams", authorize(RoleUser), listTeamsHandler)
+    router.POST("/go-timesheets/teams", authorize(RoleManager), createTeamHandler)
+    router.GET("/go-timesheets/teams/:teamId", authorize(RoleUser), getTeamHandler)
+    router.PUT("/go-timesheets/teams/:teamId/members/:uid", authorize(RoleUser), setTeamMemberHandler)
+    router.DELETE("/go-timesheets/teams/:teamId/members/:uid", authorize(RoleUser), removeTeamMemberHandler)
+    router.GET("/go-timesheets/team_invitations", authorize(RoleUser), listTeamInvitationsHandler)
+    router.POST("/go-timesheets/team_invitations/:teamId", authorize(RoleUser), acceptTeamInvitationHandler)
+    router.DELETE("/go-timesheets/team_invitations/:teamId", authorize(RoleUser), declineTeamInvitationHandler)
+    // create handlers for managers to retrieve and analyse team data
+    router.GET("/go-timesheets/teams/:teamId/data/:start/:end", authorize(RoleManager), getTeamTimeRangeDataHandler)
+    router.GET("/go-timesheets/teams/:teamId/analyse/:start/:end", authorize(RoleManager), getTeamTimeRangeAnalysisHandler)
+    router.GET("/go-timesheets/teams/:teamId/bucket_analysis/:start/:end", authorize(RoleManager), getTeamBucketAnalysisHandler)
+    // create handlers to retrieve and manage user roles
+    router.GET("/go-timesheets/roles", authorize(RoleUser), getRolesHandler)
+    router.GET("/go-timesheets/admin/roles/:uid", authorize(RoleAdmin), getUserRolesHandler)
+    router.PUT("/go-timesheets/admin/roles/:uid", authorize(RoleAdmin), setUserRolesHandler)
+    // create handlers to export and erase all account data
+    router.GET("/go-timesheets/account/export", authorize(RoleUser), exportAccountHandler)
+    router.DELETE("/go-timesheets/account", authorize(RoleUser), eraseAccountHandler)
+    router.GET("/go-timesheets/admin/users/:uid/export", authorize(RoleAdmin), exportUserAccountHandler)
+    router.DELETE("/go-timesheets/admin/users/:uid", authorize(RoleAdmin), eraseUserAccountHandler)
+    // create handlers to backup and restore all instance data
+    router.GET("/go-timesheets/admin/config", authorize(RoleAdmin), getConfigurationHandler)
+    router.GET("/go-timesheets/admin/backup", authorize(RoleAdmin), getBackupHandler)
+    router.POST("/go-timesheets/admin/restore", authorize(RoleAdmin), restoreBackupHandler)
+    // create handlers to manage personal API tokens
+    router.GET("/go-timesheets/tokens", authorize(RoleUser), listAPITokensHandler)
+    router.POST("/go-timesheets/tokens", authorize(RoleUser), createAPITokenHandler)
+    router.DELETE("/go-timesheets/tokens/:tokenId", authorize(RoleUser), revokeAPITokenHandler)
+    // create handlers to manage webhooks and inspect delivery history
+    router.GET("/go-timesheets/webhooks", authorize(RoleUser), listWebhooksHandler)
+    router.POST("/go-timesheets/webhooks", authorize(RoleUser), createWebhookHandler)
+    router.DELETE("/go-timesheets/webhooks/:webhookId", authorize(RoleUser), deleteWebhookHandler)
+    router.GET("/go-timesheets/webhooks/:webhookId/deliveries", authorize(RoleUser), listWebhookDeliveriesHandler)
+
+    // connect persistence layer in the background, meaning that the service
+    // is able to report its status while waiting for postgres to start
+    background, cancel := context.WithCancel(context.Background())
+    workers := &sync.WaitGroup{}
+    workers.Add(1)
+    go func() {
+        defer workers.Done()
+        if err := ConnectPersistence(background); err != nil {
+            if background.Err() == nil {
+                log.Fatal(err)
+            }
+            return
+        }
+        startBackgroundWorkers(background, workers)
+        setReady(true)
+        log.Info("service is ready to serve requests")
+    }()
+
+    server, err := newServer(router)
+    if err != nil {
+        log.Fatal(err)
+    }
+    server.RegisterOnShutdown(closeStreams)
+    go func() {
+        if err := serve(server); err != nil && err != http.ErrServerClosed {
+            log.Fatal(fmt.Errorf("unable to start server: %v", err))
+        }
+    }()
+
+    // wait for termination signal before draining in-flight requests and
+    // stopping all background workers. the connection pool and tracer are
+    // only closed once no more requests or workers are running
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+    log.Info(fmt.Sprintf("received signal %s, shutting down", <-signals))
+    setReady(false)
 
-    router.Run(fmt.Sprintf(":%d", ListenPort))
+    timeout, cancelTimeout := context.WithTimeout(context.Background(), time.Duration(ShutdownTimeout) * time.Second)
+    defer cancelTimeout()
+    if err := server.Shutdown(timeout); err != nil {
+        log.Error(fmt.Errorf("unable to drain in-flight requests: %v", err))
+    }
+    cancel()
+    workers.Wait()
+    ClosePersistence()
+    if err := tracer.Close(); err != nil {
+        log.Error(fmt.Errorf("unable to close tracer: %v", err))
+    }
+    log.Info("shutdown complete")
+    return nil
+}
+
+// function used to start all background workers. workers run until the
+// given context is cancelled, and are tracked using the given wait group
+func startBackgroundWorkers(ctx context.Context, workers *sync.WaitGroup) {
+    start := func(worker func(context.Context)) {
+        workers.Add(1)
+        go func() {
+            defer workers.Done()
+            worker(ctx)
+        }()
+    }
+    // start background dispatcher used to send webhook deliveries
+    if WebhooksEnabled {
+        start(runWebhookDispatcher)
+    }
+    // start listener used to push active period changes to open streams
+    start(runActivePeriodListener)
 }
 
-// function used to retrieve authenticated user ID from header
+// function used to retrieve authenticated user ID. requests are authenticated
+// using the configured authenticator on first access and the results are
+// cached in the request context
 func getUser(ctx *gin.Context) string {
-    return ctx.Request.Header.Get("X-Authenticated-Userid")
+    if uid, ok := ctx.Get("uid"); ok {
+        return uid.(string)
+    }
+    // personal API tokens are accepted regardless of authentication mode
+    authenticator := authenticators[AuthMode]
+    if isAPITokenRequest(ctx) {
+        authenticator = APITokenAuthenticator{}
+    }
+    uid, roles, err := authenticator.Authenticate(ctx)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to authenticate request: %v", err))
+    }
+    ctx.Set("uid", uid)
+    ctx.Set("tokenRoles", roles)
+    if metadata := getRequestMetadata(ctx.Request.Context()); metadata != nil {
+        metadata.Uid = uid
+    }
+    return uid
 }
 
 // handler function used for basic health checks
 func healthCheckHandler(ctx *gin.Context) {
     log.Debug("received request for health check route")
+    if !isReady() {
+        StandardHTTP.ServiceUnavailable(ctx)
+        return
+    }
     StandardHTTP.Success(ctx)
 }
 
@@ -80,7 +248,7 @@ func getActivePeriodHandler(ctx *gin.Context) {
     // retrieve current user and get active period
     user := getUser(ctx)
     log.Debug(fmt.Sprintf("received request to get active peroid for user %s", user))
-    period, err := persistence.getActivePeriod(user)
+    period, err := persistence.getActivePeriod(ctx.Request.Context(), user)
     if err != nil {
         switch err {
         case pgx.ErrNoRows:
@@ -99,7 +267,7 @@ func getUserDataHandler(ctx *gin.Context) {
     user := getUser(ctx)
     log.Debug(fmt.Sprintf("received request to get user data for user %s", user))
     // get user data from postgres database
-    data, err := persistence.getUserData(user)
+    data, err := persistence.getUserData(ctx.Request.Context(), user)
     if err != nil {
         log.Error(fmt.Errorf("unable to retrieve data for user %s: %v", user, err))
         StandardHTTP.InternalServerError(ctx)
@@ -121,7 +289,7 @@ func getUserTimeRangeDataHandler(ctx *gin.Context) {
 
     log.Debug(fmt.Sprintf("received request to get user data for user %s", user))
     // get user data from postgres database
-    data, err := persistence.getUserDataOverRange(user, start, end.Add(time.Hour * 24))
+    data, err := persistence.getUserDataOverRange(ctx.Request.Context(), user, start, end.Add(time.Hour * 24))
     if err != nil {
         log.Error(fmt.Errorf("unable to retrieve data for user %s: %v", user, err))
         StandardHTTP.InternalServerError(ctx)
@@ -141,7 +309,7 @@ func getUserTimeRangeDataHandler(ctx *gin.Context) {
 func getUserAnalysisHandler(ctx *gin.Context) {
     user := getUser(ctx)
     log.Debug(fmt.Sprintf("received analysis request for user %s", user))
-    results, err := analyzeUserTasks(user)
+    results, err := analyzeUserTasks(ctx.Request.Context(), user)
     if err != nil {
         log.Error(fmt.Errorf("unable to analyse user tasks: %v", err))
         StandardHTTP.InternalServerError(ctx)
@@ -162,7 +330,7 @@ func getUserTimeRangeAnalysisHandler(ctx *gin.Context) {
     }
     // analyse users tasks over time range
     log.Debug(fmt.Sprintf("received time range analysis request for user %s", user))
-    results, err := analyseRangedUserTasks(user, start, end)
+    results, err := analyseRangedUserTasks(ctx.Request.Context(), user, start, end)
     if err != nil {
         log.Error(fmt.Errorf("unable to analyse user tasks: %v", err))
         StandardHTTP.InternalServerError(ctx)
@@ -191,7 +359,7 @@ func getUserBucketAnalysisHandler(ctx *gin.Context) {
     }
     // execute bucket analysis and return results
     includeEmpty := strings.ToLower(ctx.DefaultQuery("include_empty", "false"))
-    results, err := executeBucketAnalysis(user, start, end, bucketSize, includeEmpty == "true")
+    results, err := executeBucketAnalysis(ctx.Request.Context(), user, start, end, bucketSize, includeEmpty == "true")
     if err != nil {
         log.Error(fmt.Errorf("unable to execute bucket analysis: %v", err))
         StandardHTTP.InternalServerError(ctx)
@@ -210,10 +378,16 @@ func createWorkPeriodHandler(ctx *gin.Context) {
     user := getUser(ctx)
     log.Debug(fmt.Sprintf("received request to create new work period for user %s", user))
     // create new work period in database
-    period, err := persistence.createWorkPeriod(user)
+    // note that periods cannot be created in approved weeks
+    period, err := persistence.createWorkPeriod(ctx.Request.Context(), user)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new work period for user %s: %v", user, err))
-        StandardHTTP.InternalServerError(ctx)
+        switch err {
+        case ErrActivePeriodExists, ErrWeekLocked:
+            StandardHTTP.ConflictWithMessage(ctx, err.Error())
+        default:
+            log.Error(fmt.Errorf("unable to create new work period for user %s: %v", user, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
         return
     }
     ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": period})
@@ -230,17 +404,60 @@ func createBreakPeriodHandler(ctx *gin.Context) {
         return
     }
 
+    if !authorizeWorkPeriod(ctx, periodId) {
+        return
+    }
+
     log.Debug(fmt.Sprintf("received request to create new bread period for user %s", user))
     // create new work period in database
-    payload, err := persistence.createBreakPeriod(periodId)
+    payload, err := persistence.createBreakPeriod(ctx.Request.Context(), periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new break period for user %s: %v", user, err))
-        StandardHTTP.InternalServerError(ctx)
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        case ErrPeriodClosed, ErrActiveBreakExists, ErrWeekLocked:
+            StandardHTTP.ConflictWithMessage(ctx, err.Error())
+        default:
+            log.Error(fmt.Errorf("unable to create new break period for user %s: %v", user, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
         return
     }
     ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
 }
 
+// function used to ensure that a work period belongs to the current user.
+// periods of other users are reported as not found so that period IDs
+// cannot be probed. the appropriate response is written if not
+func authorizeWorkPeriod(ctx *gin.Context, periodId uuid.UUID) bool {
+    uid, _, err := persistence.getWorkPeriodOwner(ctx.Request.Context(), periodId)
+    return authorizePeriodOwner(ctx, uid, err)
+}
+
+// function used to ensure that a break period belongs to the current user
+func authorizeBreakPeriod(ctx *gin.Context, breakId uuid.UUID) bool {
+    uid, _, err := persistence.getBreakPeriodOwner(ctx.Request.Context(), breakId)
+    return authorizePeriodOwner(ctx, uid, err)
+}
+
+func authorizePeriodOwner(ctx *gin.Context, uid string, err error) bool {
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return false
+    }
+    if user := getUser(ctx); uid != user {
+        log.Warn(fmt.Sprintf("user %s attempted to modify period owned by another user", user))
+        StandardHTTP.NotFound(ctx)
+        return false
+    }
+    return true
+}
+
 // function used to end a specific work period
 func endWorkPeriodHandler(ctx *gin.Context) {
     periodId, err := uuid.Parse(ctx.Param("periodId"))
@@ -249,24 +466,22 @@ func endWorkPeriodHandler(ctx *gin.Context) {
         StandardHTTP.InvalidRequestWithMessage(ctx, "invalid period id")
         return
     }
-    // check it work period exist in database
-    exists, err := isValidWorkPeriod(periodId)
-    if err != nil {
-        log.Error(fmt.Errorf("unable to get work period %s: %v", periodId, err))
-        StandardHTTP.InternalServerError(ctx)
-        return
-    }
-    if !exists {
-        log.Error(fmt.Errorf("invalid work period %s", periodId))
-        StandardHTTP.NotFound(ctx)
+    // check that work period exists and belongs to current user
+    if !authorizeWorkPeriod(ctx, periodId) {
         return
     }
-
     log.Debug(fmt.Sprintf("received request to end work period %s", periodId))
-    err = persistence.closeWorkPeriod(periodId)
+    err = persistence.closeWorkPeriod(ctx.Request.Context(), periodId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to close work period %s", periodId))
-        StandardHTTP.InternalServerError(ctx)
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        case ErrPeriodClosed, ErrWeekLocked:
+            StandardHTTP.ConflictWithMessage(ctx, err.Error())
+        default:
+            log.Error(fmt.Errorf("unable to close work period %s", periodId))
+            StandardHTTP.InternalServerError(ctx)
+        }
         return
     }
     ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully closed work period %s", periodId)})
@@ -280,24 +495,22 @@ func endBreakPeriodHandler(ctx *gin.Context) {
         StandardHTTP.InvalidRequestWithMessage(ctx, "invalid break id")
         return
     }
-    // check if break period exist in database
-    exists, err := isValidBreakPeriod(breakId)
-    if err != nil {
-        log.Error(fmt.Errorf("unable to get work period %s: %v", breakId, err))
-        StandardHTTP.InternalServerError(ctx)
-        return
-    }
-    if !exists {
-        log.Error(fmt.Errorf("invalid work period %s", breakId))
-        StandardHTTP.NotFound(ctx)
+    // check that break period exists and belongs to current user
+    if !authorizeBreakPeriod(ctx, breakId) {
         return
     }
-
     log.Debug(fmt.Sprintf("received request to end break period %s", breakId))
-    err = persistence.closeBreakPeriod(breakId)
+    err = persistence.closeBreakPeriod(ctx.Request.Context(), breakId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to close work period %s", breakId))
-        StandardHTTP.InternalServerError(ctx)
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        case ErrBreakClosed, ErrWeekLocked:
+            StandardHTTP.ConflictWithMessage(ctx, err.Error())
+        default:
+            log.Error(fmt.Errorf("unable to close work period %s", breakId))
+            StandardHTTP.InternalServerError(ctx)
+        }
         return
     }
     ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully closed work period %s", breakId)})
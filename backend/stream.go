@@ -0,0 +1,175 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "context"
+    "github.com/gin-gonic/gin"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // channel used to broadcast active period changes between instances
+    ActivePeriodChannel = "active_period_changes"
+
+    activePeriodBroker = &ActivePeriodBroker{subscribers: map[string]map[chan struct{}]bool{}}
+    // channel closed when the server shuts down, ending all open streams
+    streamsClosed = make(chan struct{})
+)
+
+// define struct used to fan out active period changes to all streams
+// opened by a user on the current instance
+type ActivePeriodBroker struct {
+    mutex       sync.Mutex
+    subscribers map[string]map[chan struct{}]bool
+}
+
+// function used to subscribe to active period changes for a user
+func(broker *ActivePeriodBroker) subscribe(uid string) chan struct{} {
+    broker.mutex.Lock()
+    defer broker.mutex.Unlock()
+    channel := make(chan struct{}, 1)
+    if _, ok := broker.subscribers[uid]; !ok {
+        broker.subscribers[uid] = map[chan struct{}]bool{}
+    }
+    broker.subscribers[uid][channel] = true
+    return channel
+}
+
+// function used to remove subscription to active period changes
+func(broker *ActivePeriodBroker) unsubscribe(uid string, channel chan struct{}) {
+    broker.mutex.Lock()
+    defer broker.mutex.Unlock()
+    delete(broker.subscribers[uid], channel)
+    if len(broker.subscribers[uid]) == 0 {
+        delete(broker.subscribers, uid)
+    }
+}
+
+// function used to notify all subscribers of a user. notifications are
+// coalesced, meaning that slow subscribers only see the latest state
+func(broker *ActivePeriodBroker) notify(uid string) {
+    broker.mutex.Lock()
+    defer broker.mutex.Unlock()
+    for channel := range(broker.subscribers[uid]) {
+        select {
+        case channel <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// function used to end all open streams. open streams are never idle,
+// meaning that they must be closed before the server is able to shut down
+func closeStreams() {
+    close(streamsClosed)
+}
+
+// function used to listen for active period changes broadcast by all
+// instances. the listener reconnects until the given context is cancelled
+func runActivePeriodListener(ctx context.Context) {
+    logger(ctx).Info(fmt.Sprintf("starting listener on channel %s", ActivePeriodChannel))
+    for {
+        if err := persistence.listenActivePeriodChanges(ctx, activePeriodBroker.notify); err != nil {
+            logger(ctx).Error(fmt.Errorf("active period listener failed: %v", err))
+            recordJobOutcome("active_period_listener", "error")
+        }
+        select {
+        case <-ctx.Done():
+            logger(ctx).Info("stopping active period listener")
+            return
+        case <-time.After(5 * time.Second):
+        }
+    }
+}
+
+// function used to write current active period of user as event
+func writeActivePeriodEvent(ctx *gin.Context, uid string) error {
+    payload := gin.H{"active": false, "period": nil}
+    period, err := persistence.getActivePeriod(ctx.Request.Context(), uid)
+    switch err {
+    case nil:
+        payload = gin.H{"active": true, "period": period}
+    case pgx.ErrNoRows:
+    default:
+        return err
+    }
+    ctx.SSEvent("active_period", payload)
+    ctx.Writer.Flush()
+    return nil
+}
+
+// function used to stream active period of current user as server-sent
+// events. the current state is sent when the stream is opened and whenever
+// a work or break period is started or ended, with periodic comments used
+// to keep idle connections open
+func streamActivePeriodHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to stream active period for user %s", user))
+    channel := activePeriodBroker.subscribe(user)
+    defer activePeriodBroker.unsubscribe(user, channel)
+
+    ctx.Header("Content-Type", "text/event-stream")
+    ctx.Header("Cache-Control", "no-cache")
+    ctx.Header("Connection", "keep-alive")
+    ctx.Header("X-Accel-Buffering", "no")
+    ctx.Status(200)
+    if err := writeActivePeriodEvent(ctx, user); err != nil {
+        log.Error(fmt.Errorf("unable to retrieve active period for user %s: %v", user, err))
+        return
+    }
+
+    heartbeat := time.NewTicker(time.Duration(StreamHeartbeatInterval) * time.Second)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-ctx.Request.Context().Done():
+            log.Debug(fmt.Sprintf("closing active period stream for user %s", user))
+            return
+        case <-streamsClosed:
+            log.Debug(fmt.Sprintf("closing active period stream for user %s on shutdown", user))
+            return
+        case <-heartbeat.C:
+            if _, err := ctx.Writer.WriteString(": heartbeat\n\n"); err != nil {
+                return
+            }
+            ctx.Writer.Flush()
+        case <-channel:
+            if err := writeActivePeriodEvent(ctx, user); err != nil {
+                log.Error(fmt.Errorf("unable to retrieve active period for user %s: %v", user, err))
+                return
+            }
+        }
+    }
+}
+
+// ###########################################################
+// # Define persistence functions used to broadcast active period changes
+// ###########################################################
+
+// function used to listen for active period changes. a dedicated
+// connection is held for the lifetime of the listener, and the callback
+// is executed with the user ID of every received notification
+func(db Persistence) listenActivePeriodChanges(ctx context.Context, callback func(string)) error {
+    conn, err := db.conn.Acquire(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Release()
+    defer conn.Exec(context.Background(), "UNLISTEN *")
+    if _, err := conn.Exec(ctx, fmt.Sprintf("LISTEN %s", ActivePeriodChannel)); err != nil {
+        return err
+    }
+    for {
+        notification, err := conn.Conn().WaitForNotification(ctx)
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            return err
+        }
+        callback(notification.Payload)
+    }
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strconv"
+    "strings"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    TeamRoleMember = "member"
+    TeamRoleManager = "manager"
+)
+
+// function used to determine if the current user is allowed to see and
+// review data for another user. admins and global managers can see all
+// users while team managers can see all members of their teams
+func canManageUser(ctx *gin.Context, member string) (bool, error) {
+    if hasRole(ctx, RoleAdmin) || isGlobalManager(getUser(ctx)) {
+        return true, nil
+    }
+    return persistence.isTeamManagerOf(ctx.Request.Context(), getUser(ctx), member)
+}
+
+// function used to determine if user has a given role within a team
+func hasTeamRole(team models.Team, uid string, roles ...string) bool {
+    for _, member := range(team.Members) {
+        if member.Uid != uid {
+            continue
+        }
+        for _, role := range(roles) {
+            if member.Role == role {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// function used to retrieve list of user ID's for all team members
+func teamMemberIds(team models.Team) []string {
+    uids := []string{}
+    for _, member := range(team.Members) {
+        uids = append(uids, member.Uid)
+    }
+    return uids
+}
+
+// function used to retrieve team from URL parameters and ensure that the
+// current user holds one of the given roles. the appropriate response is
+// written to the context if the team cannot be accessed
+func getAuthorizedTeam(ctx *gin.Context, roles ...string) (models.Team, bool) {
+    user := getUser(ctx)
+    teamId, err := uuid.Parse(ctx.Param("teamId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid team ID"))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid team id")
+        return models.Team{}, false
+    }
+    team, err := persistence.getTeam(ctx.Request.Context(), teamId)
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            log.Error(fmt.Errorf("unable to retrieve team %s: %v", teamId, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return models.Team{}, false
+    }
+    if !hasRole(ctx, RoleAdmin) && !hasTeamRole(team, user, roles...) {
+        log.Warn(fmt.Sprintf("user %s attempted to access team %s without permissions", user, teamId))
+        StandardHTTP.Forbidden(ctx)
+        return models.Team{}, false
+    }
+    return team, true
+}
+
+// function used to create a new team. the user creating the
+// team is automatically added as the manager of the team
+func createTeamHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    var request models.TeamRequest
+    if err := ctx.ShouldBindJSON(&request); err != nil {
+        log.Error(fmt.Errorf("received invalid team request: %v", err))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to create team %s for user %s", request.Name, user))
+    team, err := persistence.createTeam(ctx.Request.Context(), request.Name, user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create team for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": team})
+}
+
+// function used to list all teams that the current user belongs to
+func listTeamsHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to list teams for user %s", user))
+    teams, err := persistence.getUserTeams(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve teams for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": teams})
+}
+
+// function used to retrieve team details and members
+func getTeamHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleMember, TeamRoleManager)
+    if !ok {
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": team})
+}
+
+// function used to add a member to a team or update the role of an
+// existing member. only team managers are allowed to modify members.
+// team membership gives managers access to the data of their members, so
+// users that are not yet members are invited and only added once they
+// accept the invitation. admins add members directly
+func setTeamMemberHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleManager)
+    if !ok {
+        return
+    }
+    var request models.TeamMemberRequest
+    if err := ctx.ShouldBindJSON(&request); err != nil {
+        log.Error(fmt.Errorf("received invalid team member request: %v", err))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    if request.Role != TeamRoleMember && request.Role != TeamRoleManager {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid team role")
+        return
+    }
+    member := ctx.Param("uid")
+    if !hasRole(ctx, RoleAdmin) && !hasTeamRole(team, member, TeamRoleMember, TeamRoleManager) {
+        log.Debug(fmt.Sprintf("received request to invite user %s to team %s as %s", member, team.TeamId, request.Role))
+        if err := persistence.createTeamInvitation(ctx.Request.Context(), team.TeamId, member, request.Role, getUser(ctx)); err != nil {
+            log.Error(fmt.Errorf("unable to invite user %s to team %s: %v", member, team.TeamId, err))
+            StandardHTTP.InternalServerError(ctx)
+            return
+        }
+        ctx.JSON(202, gin.H{"success": true, "http_code": 202, "message": fmt.Sprintf("successfully invited user %s to team %s", member, team.TeamId)})
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to add user %s to team %s as %s", member, team.TeamId, request.Role))
+    if err := persistence.setTeamMember(ctx.Request.Context(), team.TeamId, member, request.Role); err != nil {
+        log.Error(fmt.Errorf("unable to add user %s to team %s: %v", member, team.TeamId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully added user %s to team %s", member, team.TeamId)})
+}
+
+// function used to list all pending team invitations of current user
+func listTeamInvitationsHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to list team invitations for user %s", user))
+    invitations, err := persistence.getTeamInvitations(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve team invitations for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": invitations})
+}
+
+// function used to accept team invitation of current user. the user is
+// added to the team with the role given in the invitation
+func acceptTeamInvitationHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    teamId, err := uuid.Parse(ctx.Param("teamId"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid team id")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to accept invitation to team %s for user %s", teamId, user))
+    if err := persistence.acceptTeamInvitation(ctx.Request.Context(), teamId, user); err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            log.Error(fmt.Errorf("unable to accept invitation to team %s for user %s: %v", teamId, user, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully joined team %s", teamId)})
+}
+
+// function used to decline team invitation of current user
+func declineTeamInvitationHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    teamId, err := uuid.Parse(ctx.Param("teamId"))
+    if err != nil {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid team id")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to decline invitation to team %s for user %s", teamId, user))
+    if err := persistence.deleteTeamInvitation(ctx.Request.Context(), teamId, user); err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            log.Error(fmt.Errorf("unable to decline invitation to team %s for user %s: %v", teamId, user, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully declined invitation to team %s", teamId)})
+}
+
+// function used to remove a member from a team
+func removeTeamMemberHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleManager)
+    if !ok {
+        return
+    }
+    member := ctx.Param("uid")
+    log.Debug(fmt.Sprintf("received request to remove user %s from team %s", member, team.TeamId))
+    if err := persistence.removeTeamMember(ctx.Request.Context(), team.TeamId, member); err != nil {
+        log.Error(fmt.Errorf("unable to remove user %s from team %s: %v", member, team.TeamId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully removed user %s from team %s", member, team.TeamId)})
+}
+
+// function used to retrieve data for all team members over a time range
+func getTeamTimeRangeDataHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleManager)
+    if !ok {
+        return
+    }
+    // get start and end time from url and parse into time.Time objects
+    start, end, err := parseTimestamps(ctx.Param("start"), ctx.Param("end"), "2006-01-02")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse timestamps: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timestamp(s)")
+        return
+    }
+
+    log.Debug(fmt.Sprintf("received request to get data for team %s", team.TeamId))
+    data, err := getTeamDataOverRange(ctx.Request.Context(), teamMemberIds(team), start, end.Add(time.Hour * 24))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve data for team %s: %v", team.TeamId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    // group values by day if specified in query parameters
+    groupValues := ctx.DefaultQuery("group", "false")
+    if strings.ToLower(groupValues) == "true" {
+        log.Debug(fmt.Sprintf("grouping periods by day"))
+        grouped := map[string]map[string][]models.WorkPeriod{}
+        for uid, periods := range(data) {
+            grouped[uid] = groupPeriodsByDay(periods, start, end.Add(time.Hour * 24))
+        }
+        ctx.JSON(200, gin.H{"success": true, "http_code": 200, "data": grouped})
+    } else {
+        ctx.JSON(200, gin.H{"success": true, "http_code": 200, "data": data})
+    }
+}
+
+// function used to return per member and aggregated analysis
+// results for all team members over a specific time range
+func getTeamTimeRangeAnalysisHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleManager)
+    if !ok {
+        return
+    }
+    // get start and end time from url and parse into time.Time objects
+    start, end, err := parseTimestamps(ctx.Param("start"), ctx.Param("end"), "2006-01-02")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse timestamps: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timestamp(s)")
+        return
+    }
+    log.Debug(fmt.Sprintf("received time range analysis request for team %s", team.TeamId))
+    results, err := analyseRangedTeamTasks(ctx.Request.Context(), teamMemberIds(team), start, end)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to analyse team tasks: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": results})
+}
+
+// function used to return per member and aggregated bucket
+// analysis for all team members over a specific time range
+func getTeamBucketAnalysisHandler(ctx *gin.Context) {
+    team, ok := getAuthorizedTeam(ctx, TeamRoleManager)
+    if !ok {
+        return
+    }
+    // get start and end time from url and parse into time.Time objects
+    start, end, err := parseTimestamps(ctx.Param("start"), ctx.Param("end"), "2006-01-02T15:04")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse timestamps: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid timestamp(s)")
+        return
+    }
+    log.Debug(fmt.Sprintf("received bucket analysis request for team %s", team.TeamId))
+    // retrieve bucket size from query string and parse to integer
+    bucketSize, err := strconv.Atoi(ctx.DefaultQuery("bucket_size", "1440"))
+    if err != nil {
+        log.Error(fmt.Errorf("received invalid bucket size: %v", err))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid bucket interval")
+        return
+    }
+    includeEmpty := strings.ToLower(ctx.DefaultQuery("include_empty", "false"))
+    members, aggregate, err := executeTeamBucketAnalysis(ctx.Request.Context(), teamMemberIds(team), start, end, bucketSize, includeEmpty == "true")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to execute bucket analysis: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    // aggregate buckets and get overview for each member
+    memberResults := gin.H{}
+    for uid, results := range(members) {
+        memberResults[uid] = gin.H{"buckets": results, "overview": aggregateBuckets(results)}
+    }
+    payload := gin.H{
+        "members": memberResults,
+        "aggregate": gin.H{"buckets": aggregate, "overview": aggregateBuckets(aggregate)},
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
+}
+
+// ###########################################################
+// # Define persistence functions used to store teams
+// ###########################################################
+
+// function used to create new team with the given user as manager
+func(db Persistence) createTeam(ctx context.Context, name, uid string) (models.Team, error) {
+    logger(ctx).Debug(fmt.Sprintf("creating new team %s for user %s", name, uid))
+    teamId := uuid.New()
+    now := time.Now()
+    _, err := db.conn.Exec(ctx, `WITH team AS (INSERT INTO teams(team_id, name, created_at) VALUES($1,$2,$3) RETURNING team_id)
+        INSERT INTO team_members(team_id, uid, role) SELECT team_id, $4, $5 FROM team`, teamId, name, now, uid, TeamRoleManager)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to create new team: %v", err))
+        return models.Team{}, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully created new team with ID %s", teamId))
+    return models.Team{TeamId: teamId, Name: name, CreatedAt: now, Members: []models.TeamMember{{Uid: uid, Role: TeamRoleManager}}}, nil
+}
+
+// function used to retrieve team and all team members from database
+func(db Persistence) getTeam(ctx context.Context, teamId uuid.UUID) (models.Team, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving team %s", teamId))
+    team := models.Team{TeamId: teamId, Members: []models.TeamMember{}}
+    result := db.conn.QueryRow(ctx, "SELECT name,created_at FROM teams WHERE team_id=$1", teamId)
+    if err := result.Scan(&team.Name, &team.CreatedAt); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve team %s: %v", teamId, err))
+        return models.Team{}, err
+    }
+
+    rows, err := db.conn.Query(ctx, "SELECT uid,role FROM team_members WHERE team_id=$1 ORDER BY uid", teamId)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve members for team %s: %v", teamId, err))
+        return models.Team{}, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var member models.TeamMember
+        if err := rows.Scan(&member.Uid, &member.Role); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process team member: %v", err))
+            return models.Team{}, err
+        }
+        team.Members = append(team.Members, member)
+    }
+    return team, rows.Err()
+}
+
+// function used to retrieve all teams that a user belongs to. note
+// that team members are not included in the returned teams
+func(db Persistence) getUserTeams(ctx context.Context, uid string) ([]models.Team, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving teams for user %s", uid))
+    teams := []models.Team{}
+    rows, err := db.conn.Query(ctx, "SELECT t.team_id,t.name,t.created_at FROM teams t JOIN team_members m ON t.team_id=m.team_id WHERE m.uid=$1 ORDER BY t.name", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve teams for user %s: %v", uid, err))
+        return teams, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var team models.Team
+        if err := rows.Scan(&team.TeamId, &team.Name, &team.CreatedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process team: %v", err))
+            return teams, err
+        }
+        teams = append(teams, team)
+    }
+    return teams, rows.Err()
+}
+
+// function used to add member to team or update role of existing member
+func(db Persistence) setTeamMember(ctx context.Context, teamId uuid.UUID, uid, role string) error {
+    logger(ctx).Debug(fmt.Sprintf("setting role of user %s in team %s to %s", uid, teamId, role))
+    _, err := db.conn.Exec(ctx, "INSERT INTO team_members(team_id, uid, role) VALUES($1,$2,$3) ON CONFLICT (team_id, uid) DO UPDATE SET role=$3", teamId, uid, role)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to set team member: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully set role of user %s in team %s", uid, teamId))
+    return nil
+}
+
+// function used to invite user to team. existing invitations are
+// replaced with the given role
+func(db Persistence) createTeamInvitation(ctx context.Context, teamId uuid.UUID, uid, role, invitedBy string) error {
+    logger(ctx).Debug(fmt.Sprintf("inviting user %s to team %s as %s", uid, teamId, role))
+    _, err := db.conn.Exec(ctx, `INSERT INTO team_invitations(team_id, uid, role, invited_by, created_at) VALUES($1,$2,$3,$4,$5)
+        ON CONFLICT (team_id, uid) DO UPDATE SET role=$3, invited_by=$4, created_at=$5`, teamId, uid, role, invitedBy, time.Now())
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to create team invitation: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully invited user %s to team %s", uid, teamId))
+    return nil
+}
+
+// function used to retrieve all pending team invitations of a user
+func(db Persistence) getTeamInvitations(ctx context.Context, uid string) ([]models.TeamInvitation, error) {
+    invitations := []models.TeamInvitation{}
+    rows, err := db.conn.Query(ctx, `SELECT i.team_id,t.name,i.role,i.invited_by,i.created_at FROM team_invitations i
+        JOIN teams t ON i.team_id=t.team_id WHERE i.uid=$1 ORDER BY i.created_at`, uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve team invitations for user %s: %v", uid, err))
+        return invitations, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        invitation := models.TeamInvitation{Uid: uid}
+        if err := rows.Scan(&invitation.TeamId, &invitation.TeamName, &invitation.Role, &invitation.InvitedBy, &invitation.CreatedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process team invitation: %v", err))
+            return invitations, err
+        }
+        invitations = append(invitations, invitation)
+    }
+    return invitations, rows.Err()
+}
+
+// function used to accept team invitation. the invitation is removed and
+// the user added to the team in a single transaction
+func(db Persistence) acceptTeamInvitation(ctx context.Context, teamId uuid.UUID, uid string) error {
+    tx, err := db.conn.Begin(ctx)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    var role string
+    if err := tx.QueryRow(ctx, "DELETE FROM team_invitations WHERE team_id=$1 AND uid=$2 RETURNING role", teamId, uid).Scan(&role); err != nil {
+        return err
+    }
+    _, err = tx.Exec(ctx, "INSERT INTO team_members(team_id, uid, role) VALUES($1,$2,$3) ON CONFLICT (team_id, uid) DO UPDATE SET role=$3", teamId, uid, role)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to add team member: %v", err))
+        return err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to commit team invitation: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("user %s successfully joined team %s as %s", uid, teamId, role))
+    return nil
+}
+
+// function used to delete team invitation
+func(db Persistence) deleteTeamInvitation(ctx context.Context, teamId uuid.UUID, uid string) error {
+    result, err := db.conn.Exec(ctx, "DELETE FROM team_invitations WHERE team_id=$1 AND uid=$2", teamId, uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to delete team invitation: %v", err))
+        return err
+    }
+    if result.RowsAffected() == 0 {
+        return pgx.ErrNoRows
+    }
+    return nil
+}
+
+// function used to remove member from team
+func(db Persistence) removeTeamMember(ctx context.Context, teamId uuid.UUID, uid string) error {
+    logger(ctx).Debug(fmt.Sprintf("removing user %s from team %s", uid, teamId))
+    _, err := db.conn.Exec(ctx, "DELETE FROM team_members WHERE team_id=$1 AND uid=$2", teamId, uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to remove team member: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully removed user %s from team %s", uid, teamId))
+    return nil
+}
+
+// function used to determine if a user manages a team that
+// another user is a member of
+func(db Persistence) isTeamManagerOf(ctx context.Context, manager, member string) (bool, error) {
+    var exists bool
+    result := db.conn.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM team_members m JOIN team_members t ON m.team_id=t.team_id
+        WHERE m.uid=$1 AND m.role=$2 AND t.uid=$3)`, manager, TeamRoleManager, member)
+    if err := result.Scan(&exists); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to evaluate team manager %s: %v", manager, err))
+        return false, err
+    }
+    return exists, nil
+}
+
+// function used to retrieve all users that are members of
+// teams managed by a given user
+func(db Persistence) getManagedUsers(ctx context.Context, manager string) ([]string, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving users managed by %s", manager))
+    uids := []string{}
+    rows, err := db.conn.Query(ctx, `SELECT DISTINCT t.uid FROM team_members m JOIN team_members t ON m.team_id=t.team_id
+        WHERE m.uid=$1 AND m.role=$2`, manager, TeamRoleManager)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve users managed by %s: %v", manager, err))
+        return uids, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var uid string
+        if err := rows.Scan(&uid); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process managed user: %v", err))
+            return uids, err
+        }
+        uids = append(uids, uid)
+    }
+    return uids, rows.Err()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strings"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/base64"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    APITokenPrefix = "gts_"
+
+    ScopeRead = "read"
+    ScopeWrite = "write"
+)
+
+// define authenticator used to validate personal API tokens. tokens are
+// given as bearer tokens and are distinguished from JWTs by their prefix
+type APITokenAuthenticator struct{}
+
+func(authenticator APITokenAuthenticator) Authenticate(ctx *gin.Context) (string, []string, error) {
+    token := strings.TrimPrefix(ctx.Request.Header.Get("Authorization"), "Bearer ")
+    apiToken, uid, err := persistence.getAPITokenByHash(ctx.Request.Context(), hashAPIToken(token))
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            return "", []string{}, ErrInvalidToken
+        default:
+            return "", []string{}, err
+        }
+    }
+    if apiToken.RevokedAt != nil {
+        return "", []string{}, ErrInvalidToken
+    }
+    ctx.Set("tokenScopes", apiToken.Scopes)
+    // update last used timestamp of token. failures are logged but do
+    // not prevent the request from being authenticated
+    if err := persistence.touchAPIToken(ctx.Request.Context(), apiToken.TokenId); err != nil {
+        log.Error(fmt.Errorf("unable to update last used timestamp of token %s: %v", apiToken.TokenId, err))
+    }
+    return uid, []string{}, nil
+}
+
+// function used to determine if request is authenticated with API token
+func isAPITokenRequest(ctx *gin.Context) bool {
+    return strings.HasPrefix(ctx.Request.Header.Get("Authorization"), "Bearer " + APITokenPrefix)
+}
+
+// function used to determine if the scopes of the API token used to
+// authenticate a request allow the request. read scopes are required
+// for GET requests while all other methods require write scopes
+func hasTokenScope(ctx *gin.Context) bool {
+    scopes, ok := ctx.Get("tokenScopes")
+    if !ok {
+        return true
+    }
+    required := ScopeWrite
+    if ctx.Request.Method == "GET" || ctx.Request.Method == "HEAD" {
+        required = ScopeRead
+    }
+    for _, scope := range(scopes.([]string)) {
+        if scope == required {
+            return true
+        }
+    }
+    return false
+}
+
+// function used to generate new random API token
+func generateAPIToken() (string, error) {
+    buffer := make([]byte, 32)
+    if _, err := rand.Read(buffer); err != nil {
+        return "", err
+    }
+    return APITokenPrefix + base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// function used to hash API token. only the hash of tokens are stored
+func hashAPIToken(token string) string {
+    hash := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(hash[:])
+}
+
+// function used to create new API token for the current user. the
+// token is only ever returned once and cannot be retrieved afterwards
+func createAPITokenHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    // prevent tokens from being used to create further tokens
+    if _, ok := ctx.Get("tokenScopes"); ok {
+        log.Warn(fmt.Sprintf("user %s attempted to create API token using API token", user))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    var request models.APITokenRequest
+    if err := ctx.ShouldBindJSON(&request); err != nil {
+        log.Error(fmt.Errorf("received invalid token request: %v", err))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    if len(request.Scopes) == 0 {
+        request.Scopes = []string{ScopeRead, ScopeWrite}
+    }
+    for _, scope := range(request.Scopes) {
+        if scope != ScopeRead && scope != ScopeWrite {
+            StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("invalid scope %s", scope))
+            return
+        }
+    }
+
+    log.Debug(fmt.Sprintf("received request to create API token for user %s", user))
+    token, err := generateAPIToken()
+    if err != nil {
+        log.Error(fmt.Errorf("unable to generate API token: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    apiToken, err := persistence.createAPIToken(ctx.Request.Context(), user, request.Name, hashAPIToken(token), request.Scopes)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create API token for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    payload := gin.H{
+        "token": token,
+        "details": apiToken,
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
+}
+
+// function used to list all API tokens for the current user
+func listAPITokensHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to list API tokens for user %s", user))
+    tokens, err := persistence.getAPITokens(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve API tokens for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": tokens})
+}
+
+// function used to revoke API token for the current user
+func revokeAPITokenHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    tokenId, err := uuid.Parse(ctx.Param("tokenId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid token ID"))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid token id")
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to revoke API token %s", tokenId))
+    revoked, err := persistence.revokeAPIToken(ctx.Request.Context(), user, tokenId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to revoke API token %s: %v", tokenId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    if !revoked {
+        StandardHTTP.NotFound(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully revoked token %s", tokenId)})
+}
+
+// ###########################################################
+// # Define persistence functions used to store API tokens
+// ###########################################################
+
+// function used to store new API token. note that only the hash
+// of the token is stored in the database
+func(db Persistence) createAPIToken(ctx context.Context, uid, name, tokenHash string, scopes []string) (models.APIToken, error) {
+    logger(ctx).Debug(fmt.Sprintf("creating new API token for user %s", uid))
+    tokenId := uuid.New()
+    now := time.Now()
+    _, err := db.conn.Exec(ctx, "INSERT INTO api_tokens(token_id, uid, name, token_hash, scopes, created_at) VALUES($1,$2,$3,$4,$5,$6)",
+        tokenId, uid, name, tokenHash, scopes, now)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to create new API token: %v", err))
+        return models.APIToken{}, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully created new API token with ID %s", tokenId))
+    return models.APIToken{TokenId: tokenId, Name: name, Scopes: scopes, CreatedAt: now}, nil
+}
+
+// function used to retrieve API token and owner given the token hash
+func(db Persistence) getAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, string, error) {
+    var (token models.APIToken; uid string)
+    result := db.conn.QueryRow(ctx, "SELECT token_id,uid,name,scopes,created_at,last_used_at,revoked_at FROM api_tokens WHERE token_hash=$1", tokenHash)
+    err := result.Scan(&token.TokenId, &uid, &token.Name, &token.Scopes, &token.CreatedAt, &token.LastUsedAt, &token.RevokedAt)
+    if err != nil {
+        return models.APIToken{}, uid, err
+    }
+    return token, uid, nil
+}
+
+// function used to retrieve all API tokens for a given user
+func(db Persistence) getAPITokens(ctx context.Context, uid string) ([]models.APIToken, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving API tokens for user %s", uid))
+    tokens := []models.APIToken{}
+    rows, err := db.conn.Query(ctx, "SELECT token_id,name,scopes,created_at,last_used_at,revoked_at FROM api_tokens WHERE uid=$1 ORDER BY created_at DESC", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve API tokens for user %s: %v", uid, err))
+        return tokens, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var token models.APIToken
+        if err := rows.Scan(&token.TokenId, &token.Name, &token.Scopes, &token.CreatedAt, &token.LastUsedAt, &token.RevokedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process API token: %v", err))
+            return tokens, err
+        }
+        tokens = append(tokens, token)
+    }
+    return tokens, rows.Err()
+}
+
+// function used to update last used timestamp of API token
+func(db Persistence) touchAPIToken(ctx context.Context, tokenId uuid.UUID) error {
+    _, err := db.conn.Exec(ctx, "UPDATE api_tokens SET last_used_at=$1 WHERE token_id=$2", time.Now(), tokenId)
+    return err
+}
+
+// function used to revoke API token. tokens can only be revoked by their owner
+func(db Persistence) revokeAPIToken(ctx context.Context, uid string, tokenId uuid.UUID) (bool, error) {
+    logger(ctx).Debug(fmt.Sprintf("revoking API token %s", tokenId))
+    result, err := db.conn.Exec(ctx, "UPDATE api_tokens SET revoked_at=$1 WHERE token_id=$2 AND uid=$3 AND revoked_at IS NULL", time.Now(), tokenId, uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to revoke API token: %v", err))
+        return false, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully revoked API token %s", tokenId))
+    return result.RowsAffected() > 0, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "io"
+    "fmt"
+    "time"
+    "context"
+    log "github.com/sirupsen/logrus"
+    opentracing "github.com/opentracing/opentracing-go"
+    jaeger_client "github.com/uber/jaeger-client-go"
+    jaeger_config "github.com/uber/jaeger-client-go/config"
+    "go.opentelemetry.io/otel/api/global"
+    "go.opentelemetry.io/otel/exporters/otlp"
+    "go.opentelemetry.io/otel/propagators"
+    "go.opentelemetry.io/otel/sdk/resource"
+    "go.opentelemetry.io/otel/semconv"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    otelbridge "go.opentelemetry.io/otel/bridge/opentracing"
+)
+
+var (
+    TracingDisabled = "none"
+
+    // define map of supported tracing exporters. all exporters register a
+    // global opentracing tracer used by the jaeger negroni middleware
+    tracingExporters = map[string]TracerFactory{
+        "jaeger": newJaegerTracer,
+        "otlp": newOTLPTracer,
+        TracingDisabled: func() (io.Closer, error) { return noopCloser{}, nil },
+    }
+)
+
+// define function used to create new tracer for a given exporter
+type TracerFactory func() (io.Closer, error)
+
+type noopCloser struct {}
+
+func(closer noopCloser) Close() error {
+    return nil
+}
+
+// define closer used to flush and shut down OpenTelemetry exporters
+type otlpCloser struct {
+    processor *sdktrace.BatchSpanProcessor
+    exporter  *otlp.Exporter
+}
+
+func(closer otlpCloser) Close() error {
+    closer.processor.Shutdown()
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    return closer.exporter.Shutdown(ctx)
+}
+
+// function used to create new tracer that reports spans to a jaeger agent.
+// spans are sampled with the configured sampling rate
+func newJaegerTracer() (io.Closer, error) {
+    sampler := &jaeger_config.SamplerConfig{Type: jaeger_client.SamplerTypeProbabilistic, Param: TracingSampleRate}
+    if TracingSampleRate >= 1 {
+        sampler = &jaeger_config.SamplerConfig{Type: jaeger_client.SamplerTypeConst, Param: 1}
+    }
+    config := jaeger_config.Configuration{
+        ServiceName: TracingServiceName,
+        Sampler: sampler,
+        Reporter: &jaeger_config.ReporterConfig{
+            LocalAgentHostPort: fmt.Sprintf("%s:%d", JaegerAgentHost, JaegerAgentPort),
+        },
+    }
+    return config.InitGlobalTracer(TracingServiceName)
+}
+
+// function used to create new tracer that exports spans using the
+// OpenTelemetry protocol. spans created by the opentracing middleware are
+// forwarded to OpenTelemetry using the opentracing bridge
+func newOTLPTracer() (io.Closer, error) {
+    options := []otlp.ExporterOption{otlp.WithAddress(OTLPEndpoint)}
+    if OTLPInsecure {
+        options = append(options, otlp.WithInsecure())
+    }
+    exporter, err := otlp.NewExporter(options...)
+    if err != nil {
+        return nil, err
+    }
+    processor := sdktrace.NewBatchSpanProcessor(exporter)
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(TracingSampleRate))}),
+        sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(TracingServiceName))),
+        sdktrace.WithSpanProcessor(processor),
+    )
+    bridge, wrapper := otelbridge.NewTracerPair(provider.Tracer(TracingServiceName))
+    global.SetTracerProvider(wrapper)
+    global.SetTextMapPropagator(propagators.TraceContext{})
+    opentracing.SetGlobalTracer(bridge)
+    return otlpCloser{processor: processor, exporter: exporter}, nil
+}
+
+// function used to set up tracing using the configured exporter. failures
+// to create the tracer are not fatal, and tracing is disabled instead
+func setupTracing() io.Closer {
+    if TracingExporter == TracingDisabled {
+        log.Info("tracing is disabled")
+        return noopCloser{}
+    }
+    log.Info(fmt.Sprintf("creating new %s tracer for service %s with sampling rate %.2f", TracingExporter, TracingServiceName, TracingSampleRate))
+    tracer, err := tracingExporters[TracingExporter]()
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to create %s tracer, disabling tracing: %v", TracingExporter, err))
+        TracingExporter = TracingDisabled
+        return noopCloser{}
+    }
+    return tracer
+}
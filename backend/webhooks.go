@@ -0,0 +1,524 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "net"
+    "bytes"
+    "errors"
+    "context"
+    "strings"
+    "syscall"
+    "sync"
+    "net/url"
+    "net/http"
+    "io/ioutil"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "github.com/PSauerborn/go-timesheets/models"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    EventWorkPeriodCreated = "work_period.created"
+    EventWorkPeriodClosed = "work_period.closed"
+    EventBreakPeriodCreated = "break_period.created"
+    EventBreakPeriodClosed = "break_period.closed"
+    // work periods are only updated when restoring backups. breaks are also
+    // updated and deleted by maintenance commands and when work periods are
+    // closed before breaks that were started later
+    EventWorkPeriodUpdated = "work_period.updated"
+    EventBreakPeriodUpdated = "break_period.updated"
+    EventBreakPeriodDeleted = "break_period.deleted"
+
+    webhookEvents = []string{EventWorkPeriodCreated, EventWorkPeriodClosed, EventBreakPeriodCreated, EventBreakPeriodClosed,
+        EventWorkPeriodUpdated, EventBreakPeriodUpdated, EventBreakPeriodDeleted}
+
+    DeliveryPending = "pending"
+    DeliveryDelivered = "delivered"
+    DeliveryFailed = "failed"
+
+    ErrForbiddenWebhookAddress = errors.New("webhook address is not publicly routable")
+
+    // address ranges that are not covered by the net.IP helpers
+    nonPublicWebhookNetworks = []*net.IPNet{
+        mustParseCIDR("0.0.0.0/8"),
+        mustParseCIDR("100.64.0.0/10"),
+    }
+)
+
+// function used to parse CIDR notation of static network ranges
+func mustParseCIDR(value string) *net.IPNet {
+    _, network, err := net.ParseCIDR(value)
+    if err != nil {
+        panic(err)
+    }
+    return network
+}
+
+// function used to determine if webhooks can be delivered to the given IP.
+// loopback, private, shared (CGNAT), link-local (including cloud metadata
+// endpoints), multicast and unspecified addresses are rejected so that
+// webhooks cannot be used to send requests to internal services
+func isPublicWebhookAddress(ip net.IP) bool {
+    for _, network := range(nonPublicWebhookNetworks) {
+        if network.Contains(ip) {
+            return false
+        }
+    }
+    return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// function used to create HTTP client used to send webhook deliveries. the
+// address is validated after the host has been resolved and before the
+// connection is opened, meaning that hostnames resolving to internal
+// addresses (including via DNS rebinding) are rejected. proxies are not
+// used and redirects are not followed for the same reason
+func newWebhookClient() *http.Client {
+    dialer := &net.Dialer{
+        Timeout: time.Duration(WebhookTimeout) * time.Second,
+        Control: func(network, address string, conn syscall.RawConn) error {
+            host, _, err := net.SplitHostPort(address)
+            if err != nil {
+                return err
+            }
+            if ip := net.ParseIP(host); ip == nil || !isPublicWebhookAddress(ip) {
+                return ErrForbiddenWebhookAddress
+            }
+            return nil
+        },
+    }
+    return &http.Client{
+        Timeout: time.Duration(WebhookTimeout) * time.Second,
+        Transport: &http.Transport{
+            Proxy: nil,
+            DialContext: dialer.DialContext,
+            TLSHandshakeTimeout: 10 * time.Second,
+            MaxIdleConns: 10,
+            IdleConnTimeout: 90 * time.Second,
+        },
+        CheckRedirect: func(request *http.Request, via []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+}
+
+// function used to determine if event is supported by webhooks
+func isValidWebhookEvent(event string) bool {
+    for _, valid := range(webhookEvents) {
+        if valid == event {
+            return true
+        }
+    }
+    return false
+}
+
+// function used to generate random secret used to sign webhook deliveries
+func generateWebhookSecret() (string, error) {
+    buffer := make([]byte, 32)
+    if _, err := rand.Read(buffer); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(buffer), nil
+}
+
+// function used to sign webhook payload using HMAC-SHA256
+func signWebhookPayload(secret string, payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(payload)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// function used to evaluate delay before next delivery attempt. delays
+// grow exponentially with the number of attempts and are capped at one day
+func webhookBackoff(attempts int) time.Duration {
+    delay := time.Duration(WebhookBackoffBase) * time.Second
+    for i := 1; i < attempts && delay < time.Hour * 24; i++ {
+        delay *= 2
+    }
+    if delay > time.Hour * 24 {
+        delay = time.Hour * 24
+    }
+    return delay
+}
+
+// function used to send a single webhook delivery. deliveries are
+// considered successful if the endpoint responds with a 2xx status
+func sendWebhookDelivery(ctx context.Context, client *http.Client, delivery models.WebhookDelivery, webhook models.Webhook) (int, error) {
+    request, err := http.NewRequestWithContext(ctx, "POST", webhook.Url, bytes.NewReader(delivery.Payload))
+    if err != nil {
+        return 0, err
+    }
+    request.Header.Set("Content-Type", "application/json")
+    request.Header.Set("User-Agent", "go-timesheets-webhooks")
+    request.Header.Set("X-Timesheets-Event", delivery.Event)
+    request.Header.Set("X-Timesheets-Delivery", delivery.DeliveryId.String())
+    request.Header.Set("X-Timesheets-Signature", signWebhookPayload(webhook.Secret, delivery.Payload))
+    response, err := client.Do(request)
+    if err != nil {
+        return 0, err
+    }
+    defer response.Body.Close()
+    ioutil.ReadAll(response.Body)
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return response.StatusCode, fmt.Errorf("received response code %d", response.StatusCode)
+    }
+    return response.StatusCode, nil
+}
+
+// function used to process all pending webhook deliveries that are due.
+// claimed deliveries are sent concurrently so that the whole batch is
+// completed within the lease acquired when claiming the deliveries
+func dispatchWebhookDeliveries(ctx context.Context, client *http.Client) {
+    deliveries, err := persistence.claimWebhookDeliveries(ctx, 50)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to claim webhook deliveries: %v", err))
+        recordJobOutcome("webhook_dispatch", "error")
+        return
+    }
+    recordJobOutcome("webhook_dispatch", "success")
+    var group sync.WaitGroup
+    for _, claimed := range(deliveries) {
+        group.Add(1)
+        go func(delivery models.WebhookDelivery, webhook models.Webhook) {
+            defer group.Done()
+            processWebhookDelivery(ctx, client, delivery, webhook)
+        }(claimed.Delivery, claimed.Webhook)
+    }
+    group.Wait()
+}
+
+// function used to send a claimed webhook delivery and record the result.
+// failed deliveries are rescheduled until the maximum number of attempts
+// has been reached
+func processWebhookDelivery(ctx context.Context, client *http.Client, delivery models.WebhookDelivery, webhook models.Webhook) {
+    code, err := sendWebhookDelivery(ctx, client, delivery, webhook)
+    if ctx.Err() != nil {
+        // delivery is retried once the lease expires
+        logger(ctx).Warn(fmt.Sprintf("webhook delivery %s cancelled during shutdown", delivery.DeliveryId))
+        return
+    }
+    attempts := delivery.Attempts + 1
+    status, nextAttempt, lastError := DeliveryDelivered, time.Now(), ""
+    if err != nil {
+        logger(ctx).Warn(fmt.Sprintf("unable to deliver webhook %s to %s (attempt %d): %v", delivery.DeliveryId, webhook.Url, attempts, err))
+        status, nextAttempt, lastError = DeliveryPending, time.Now().Add(webhookBackoff(attempts)), err.Error()
+        if attempts >= WebhookMaxAttempts {
+            status = DeliveryFailed
+        }
+    }
+    recordJobOutcome("webhook_delivery", status)
+    if err := persistence.updateWebhookDelivery(ctx, delivery.DeliveryId, status, attempts, nextAttempt, code, lastError); err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to update webhook delivery %s: %v", delivery.DeliveryId, err))
+    }
+}
+
+// function used to run webhook dispatcher. the outbox is polled at the
+// configured interval until the given context is cancelled
+func runWebhookDispatcher(ctx context.Context) {
+    logger(ctx).Info(fmt.Sprintf("starting webhook dispatcher with poll interval %ds", WebhookPollInterval))
+    client := newWebhookClient()
+    ticker := time.NewTicker(time.Duration(WebhookPollInterval) * time.Second)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            logger(ctx).Info("stopping webhook dispatcher")
+            return
+        case <-ticker.C:
+            dispatchWebhookDeliveries(ctx, client)
+        }
+    }
+}
+
+// function used to register new webhook. global webhooks receive events
+// for all users and can only be registered by admins. the signing secret
+// is only returned when the webhook is created
+func createWebhookHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    var request models.WebhookRequest
+    if err := ctx.ShouldBindJSON(&request); err != nil {
+        log.Error(fmt.Errorf("received invalid webhook request: %v", err))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    target, err := url.Parse(request.Url)
+    if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid webhook url")
+        return
+    }
+    // reject internal addresses early. hostnames are validated when resolved during delivery
+    if ip := net.ParseIP(target.Hostname()); strings.EqualFold(target.Hostname(), "localhost") || (ip != nil && !isPublicWebhookAddress(ip)) {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "webhook url must not point to an internal address")
+        return
+    }
+    if len(request.Events) == 0 {
+        StandardHTTP.InvalidRequestWithMessage(ctx, "at least one event is required")
+        return
+    }
+    for _, event := range(request.Events) {
+        if !isValidWebhookEvent(event) {
+            StandardHTTP.InvalidRequestWithMessage(ctx, fmt.Sprintf("invalid event %s", event))
+            return
+        }
+    }
+    if request.Global && !hasRole(ctx, RoleAdmin) {
+        log.Warn(fmt.Sprintf("user %s attempted to create global webhook without admin permissions", user))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+
+    log.Debug(fmt.Sprintf("received request to create webhook for user %s", user))
+    secret, err := generateWebhookSecret()
+    if err != nil {
+        log.Error(fmt.Errorf("unable to generate webhook secret: %v", err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    webhook, err := persistence.createWebhook(ctx.Request.Context(), user, request.Url, secret, request.Events, request.Global)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create webhook for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    payload := gin.H{
+        "secret": secret,
+        "details": webhook,
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": payload})
+}
+
+// function used to list all webhooks registered by current user
+func listWebhooksHandler(ctx *gin.Context) {
+    user := getUser(ctx)
+    log.Debug(fmt.Sprintf("received request to list webhooks for user %s", user))
+    webhooks, err := persistence.getWebhooks(ctx.Request.Context(), user)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve webhooks for user %s: %v", user, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": webhooks})
+}
+
+// function used to retrieve webhook from URL parameters. webhooks can only
+// be accessed by their owner. the appropriate response is written to the
+// context if the webhook cannot be accessed
+func getOwnedWebhook(ctx *gin.Context) (models.Webhook, bool) {
+    webhookId, err := uuid.Parse(ctx.Param("webhookId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid webhook ID"))
+        StandardHTTP.InvalidRequestWithMessage(ctx, "invalid webhook id")
+        return models.Webhook{}, false
+    }
+    webhook, err := persistence.getWebhook(ctx.Request.Context(), webhookId)
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            log.Error(fmt.Errorf("unable to retrieve webhook %s: %v", webhookId, err))
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return models.Webhook{}, false
+    }
+    if webhook.Uid != getUser(ctx) {
+        StandardHTTP.NotFound(ctx)
+        return models.Webhook{}, false
+    }
+    return webhook, true
+}
+
+// function used to delete webhook. pending deliveries are discarded
+func deleteWebhookHandler(ctx *gin.Context) {
+    webhook, ok := getOwnedWebhook(ctx)
+    if !ok {
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to delete webhook %s", webhook.WebhookId))
+    if err := persistence.deleteWebhook(ctx.Request.Context(), webhook.WebhookId); err != nil {
+        log.Error(fmt.Errorf("unable to delete webhook %s: %v", webhook.WebhookId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "message": fmt.Sprintf("successfully deleted webhook %s", webhook.WebhookId)})
+}
+
+// function used to retrieve delivery history of webhook
+func listWebhookDeliveriesHandler(ctx *gin.Context) {
+    webhook, ok := getOwnedWebhook(ctx)
+    if !ok {
+        return
+    }
+    status := ctx.Query("status")
+    log.Debug(fmt.Sprintf("received request to list deliveries for webhook %s", webhook.WebhookId))
+    deliveries, err := persistence.getWebhookDeliveries(ctx.Request.Context(), webhook.WebhookId, status)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve deliveries for webhook %s: %v", webhook.WebhookId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{"success": true, "http_code": 200, "payload": deliveries})
+}
+
+// ###########################################################
+// # Define persistence functions used to store webhooks and deliveries
+// ###########################################################
+
+// function used to create new webhook
+func(db Persistence) createWebhook(ctx context.Context, uid, url, secret string, events []string, global bool) (models.Webhook, error) {
+    logger(ctx).Debug(fmt.Sprintf("creating new webhook for user %s", uid))
+    webhookId := uuid.New()
+    now := time.Now()
+    _, err := db.conn.Exec(ctx, "INSERT INTO webhooks(webhook_id, uid, url, secret, events, global, created_at) VALUES($1,$2,$3,$4,$5,$6,$7)",
+        webhookId, uid, url, secret, events, global, now)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to create new webhook: %v", err))
+        return models.Webhook{}, err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully created new webhook with ID %s", webhookId))
+    return models.Webhook{WebhookId: webhookId, Uid: uid, Url: url, Events: events, Global: global, CreatedAt: now}, nil
+}
+
+// function used to retrieve webhook given webhook ID
+func(db Persistence) getWebhook(ctx context.Context, webhookId uuid.UUID) (models.Webhook, error) {
+    var webhook models.Webhook
+    result := db.conn.QueryRow(ctx, "SELECT webhook_id,uid,url,secret,events,global,created_at FROM webhooks WHERE webhook_id=$1", webhookId)
+    err := result.Scan(&webhook.WebhookId, &webhook.Uid, &webhook.Url, &webhook.Secret, &webhook.Events, &webhook.Global, &webhook.CreatedAt)
+    if err != nil {
+        return models.Webhook{}, err
+    }
+    return webhook, nil
+}
+
+// function used to retrieve all webhooks registered by a user
+func(db Persistence) getWebhooks(ctx context.Context, uid string) ([]models.Webhook, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving webhooks for user %s", uid))
+    webhooks := []models.Webhook{}
+    rows, err := db.conn.Query(ctx, "SELECT webhook_id,uid,url,events,global,created_at FROM webhooks WHERE uid=$1 ORDER BY created_at", uid)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve webhooks for user %s: %v", uid, err))
+        return webhooks, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var webhook models.Webhook
+        if err := rows.Scan(&webhook.WebhookId, &webhook.Uid, &webhook.Url, &webhook.Events, &webhook.Global, &webhook.CreatedAt); err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process webhook: %v", err))
+            return webhooks, err
+        }
+        webhooks = append(webhooks, webhook)
+    }
+    return webhooks, rows.Err()
+}
+
+// function used to delete webhook along with all deliveries
+func(db Persistence) deleteWebhook(ctx context.Context, webhookId uuid.UUID) error {
+    logger(ctx).Debug(fmt.Sprintf("deleting webhook %s", webhookId))
+    _, err := db.conn.Exec(ctx, "DELETE FROM webhooks WHERE webhook_id=$1", webhookId)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to delete webhook: %v", err))
+        return err
+    }
+    logger(ctx).Info(fmt.Sprintf("successfully deleted webhook %s", webhookId))
+    return nil
+}
+
+// function used to write deliveries to the outbox for all webhooks that are
+// subscribed to an event, either registered by the user or registered globally.
+// deliveries are written using the transaction that records the event, meaning
+// that deliveries only exist for changes that have been committed
+func enqueueWebhookDeliveries(ctx context.Context, tx pgx.Tx, uid, event string, payload []byte) error {
+    rows, err := tx.Query(ctx, "SELECT webhook_id FROM webhooks WHERE (uid=$1 OR global) AND $2=ANY(events)", uid, event)
+    if err != nil {
+        return err
+    }
+    webhookIds := []uuid.UUID{}
+    for rows.Next() {
+        var webhookId uuid.UUID
+        if err := rows.Scan(&webhookId); err != nil {
+            rows.Close()
+            return err
+        }
+        webhookIds = append(webhookIds, webhookId)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    now := time.Now()
+    for _, webhookId := range(webhookIds) {
+        _, err := tx.Exec(ctx, `INSERT INTO webhook_deliveries(delivery_id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+            VALUES($1,$2,$3,$4,$5,0,$6,$6)`, uuid.New(), webhookId, event, payload, DeliveryPending, now)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to claim pending deliveries that are due. claimed deliveries
+// are leased by pushing back their next attempt, preventing other instances
+// from sending the same delivery while it is in flight. the lease covers
+// twice the webhook timeout, meaning that claimed deliveries must be sent
+// concurrently rather than one after the other
+func(db Persistence) claimWebhookDeliveries(ctx context.Context, limit int) ([]models.ClaimedWebhookDelivery, error) {
+    deliveries := []models.ClaimedWebhookDelivery{}
+    rows, err := db.conn.Query(ctx, `UPDATE webhook_deliveries d SET next_attempt_at=$1 FROM webhooks w
+        WHERE d.webhook_id=w.webhook_id AND d.delivery_id IN (
+            SELECT delivery_id FROM webhook_deliveries WHERE status=$2 AND next_attempt_at<=$3 ORDER BY next_attempt_at LIMIT $4 FOR UPDATE SKIP LOCKED)
+        RETURNING d.delivery_id,d.event,d.payload,d.attempts,w.webhook_id,w.url,w.secret`,
+        time.Now().Add(time.Duration(WebhookTimeout) * time.Second * 2), DeliveryPending, time.Now(), limit)
+    if err != nil {
+        return deliveries, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var claimed models.ClaimedWebhookDelivery
+        err := rows.Scan(&claimed.Delivery.DeliveryId, &claimed.Delivery.Event, &claimed.Delivery.Payload, &claimed.Delivery.Attempts,
+            &claimed.Webhook.WebhookId, &claimed.Webhook.Url, &claimed.Webhook.Secret)
+        if err != nil {
+            return deliveries, err
+        }
+        deliveries = append(deliveries, claimed)
+    }
+    return deliveries, rows.Err()
+}
+
+// function used to record result of delivery attempt
+func(db Persistence) updateWebhookDelivery(ctx context.Context, deliveryId uuid.UUID, status string, attempts int, nextAttempt time.Time, code int, lastError string) error {
+    _, err := db.conn.Exec(ctx, `UPDATE webhook_deliveries SET status=$1, attempts=$2, next_attempt_at=$3, last_attempt_at=$4,
+        response_code=NULLIF($5, 0), last_error=NULLIF($6, '') WHERE delivery_id=$7`, status, attempts, nextAttempt, time.Now(), code, lastError, deliveryId)
+    return err
+}
+
+// function used to retrieve delivery history of webhook. deliveries
+// can optionally be filtered by status
+func(db Persistence) getWebhookDeliveries(ctx context.Context, webhookId uuid.UUID, status string) ([]models.WebhookDelivery, error) {
+    logger(ctx).Debug(fmt.Sprintf("retrieving deliveries for webhook %s", webhookId))
+    deliveries := []models.WebhookDelivery{}
+    rows, err := db.conn.Query(ctx, `SELECT delivery_id,event,payload,status,attempts,next_attempt_at,last_attempt_at,response_code,last_error,created_at
+        FROM webhook_deliveries WHERE webhook_id=$1 AND ($2='' OR status=$2) ORDER BY created_at DESC LIMIT 100`, webhookId, status)
+    if err != nil {
+        logger(ctx).Error(fmt.Errorf("unable to retrieve deliveries for webhook %s: %v", webhookId, err))
+        return deliveries, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var delivery models.WebhookDelivery
+        err := rows.Scan(&delivery.DeliveryId, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt,
+            &delivery.LastAttemptAt, &delivery.ResponseCode, &delivery.LastError, &delivery.CreatedAt)
+        if err != nil {
+            logger(ctx).Error(fmt.Errorf("unable to process webhook delivery: %v", err))
+            return deliveries, err
+        }
+        deliveries = append(deliveries, delivery)
+    }
+    return deliveries, rows.Err()
+}